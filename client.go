@@ -2,6 +2,9 @@ package metrics
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
 	"runtime"
 	"sync"
 	"time"
@@ -11,9 +14,10 @@ import (
 	"github.com/mixpanel/mixpanel-go"
 	"github.com/pkg/errors"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
 	ddotel "gopkg.in/DataDog/dd-trace-go.v1/ddtrace/opentelemetry"
+	ddtracer "gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/profiler"
 
 	log "github.com/InjectiveLabs/suplog"
@@ -23,24 +27,36 @@ const (
 	DatadogAgent  = "datadog"
 	TelegrafAgent = "telegraf"
 	OTELAgent     = "otel"
+	StdoutAgent   = "stdout"
+	InfluxDBAgent = "influxdb"
+	GraphiteAgent = "graphite"
+
+	TraceExporterDatadog = "datadog"
+	TraceExporterOTLP    = "otlp"
+	TraceExporterStdout  = "stdout"
 )
 
 var (
-	ErrUnsupportedAgent = errors.New("unsupported agent type")
+	ErrUnsupportedAgent         = errors.New("unsupported agent type")
+	ErrUnsupportedTraceExporter = errors.New("unsupported trace exporter type")
+	ErrUnsupportedAddr          = errors.New("unsupported agent address")
 
 	client    Statter
 	clientMux = new(sync.RWMutex)
 	config    *StatterConfig
 
 	traceProviderShutdownFn func() error
+	logProviderShutdownFn   func() error
 	tracer                  trace.Tracer
+	traceRecorder           *TraceRecorder
+	localProfiler           *slowProfiler
 	mixPanelClient          *mixpanel.ApiClient
 )
 
 type StatterConfig struct {
-	Addr                 string            // localhost:8125
+	Addr                 string            // localhost:8125, or unix:// and unixgram:// datagram sockets for the Datadog and Telegraf agents, unixstream:// for Datadog only
 	Prefix               string            // metrics prefix
-	Agent                string            // telegraf/datadog
+	Agent                string            // telegraf/datadog/otel/stdout/influxdb/graphite, stdout writes to the file at addr or stdout if addr is empty
 	EnvName              string            // dev/test/staging/prod
 	HostName             string            // hostname
 	Version              string            // version
@@ -55,13 +71,79 @@ type StatterConfig struct {
 	MixPanelProjectToken string            // MixPanel project token
 	OTELInsecure         bool              // disable TLS (use for self-hosted SigNoz without TLS)
 	OTELHeaders          map[string]string // extra headers, e.g. {"signoz-access-token": "<token>"} for SigNoz Cloud
+	OTELLogsEnabled      bool              // whether suplog records should be exported over OTLP to the OTEL agent
+	OTELLogsMaxQueueSize int               // max log records buffered for export before new ones are dropped, 2048 by default
+
+	InfluxOrg           string        // organization of InfluxDB HTTP writes
+	InfluxBucket        string        // bucket of InfluxDB HTTP writes
+	InfluxToken         string        // API token of InfluxDB HTTP writes
+	InfluxBatchSize     int           // points written to InfluxDB at once, 5000 by default
+	InfluxFlushInterval time.Duration // max time points are buffered before written to InfluxDB, 1s by default
+
+	GraphiteFoldTags      []string      // tag keys whose values are appended to the dotted path in this order, for carbon without tag support
+	GraphitePercentiles   []float64     // percentiles of timings and histograms sent per flush, {50, 95, 99} by default
+	GraphiteFlushInterval time.Duration // how often aggregated points are sent to carbon, 10s by default
+	GraphiteReservoirSize int           // max timings and histogram values kept per series each flush for percentiles, 1024 by default
+
+	TelegrafAggregationEnabled  bool          // whether Telegraf counts and gauges are aggregated per name and tags, and timings sampled, before sent
+	TelegrafFlushInterval       time.Duration // how often aggregated points are sent to Telegraf, 10s by default
+	TelegrafTimingReservoirSize int           // max timings and histogram values sent per name and tags each flush, 256 by default
+	TelegrafMaxPacketSize       int           // max bytes of a datagram sent to Telegraf, 1432 by default
+
+	OriginDetectionDisabled bool // whether dogstatsd stops sending the container ID the Datadog agent uses to detect the origin of metrics, the first Datadog client of the process decides
+
+	SampleRates map[string]float64 // per-metric-name sample rates replacing the rate of the call, e.g. {"func.called": 0.1}; func.called and func.error are sampled at 0.77 by default
+
+	RecordFile string // path of a JSONL file every statter call is also appended to, see Replay and cmd/metrics-replay
+
+	StdoutFormat        string        // text/json, how the stdout agent writes metric points and span trees, text by default
+	StdoutFlushInterval time.Duration // how often stdout agent output is flushed, 1s by default
+
+	TraceExporter string            // datadog/otlp/stdout, the exporter matching Agent by default, so e.g. Telegraf metrics can be combined with OTLP traces
+	TraceEndpoint string            // host:port of the trace collector, the metrics addr for otlp and the Datadog tracer default for datadog if empty
+	TraceHeaders  map[string]string // extra headers of otlp trace exports, OTELHeaders if empty and TraceEndpoint isn't set
+	TraceInsecure bool              // disable TLS of otlp trace exports, OTELInsecure also applies when TraceEndpoint isn't set
+
+	TraceTLSCAFile     string // PEM file of the CAs verifying the otlp trace collector, the system pool by default
+	TraceTLSCertFile   string // PEM client certificate of otlp trace exports, for collectors requiring mTLS, used with TraceTLSKeyFile
+	TraceTLSKeyFile    string // PEM key of TraceTLSCertFile
+	TraceTLSServerName string // name the collector certificate is verified against, the TraceEndpoint host by default
+
+	TraceSampleRatio     float64            // fraction of root spans sampled by the OTEL tracer, all of them by default; child spans follow their parent
+	TraceSampleOverrides map[string]float64 // per-span-name ratios replacing TraceSampleRatio, e.g. {"BeginBlocker": 0.01}
+	TraceSampleRateLimit float64            // max sampled root spans per second, unlimited by default
+
+	TailSamplingEnabled  bool          // whether spans are buffered per trace and exported only for failing, slow or ratio-sampled traces
+	TailSampleRatio      float64       // fraction of traces kept without errors or slow spans, none by default
+	TailLatencyThreshold time.Duration // traces with a span at least this slow are kept, 1s by default
+	TailMaxTraces        int           // max buffered traces, the oldest one is decided early when exceeded, 10000 by default
+	TailMaxTraceAge      time.Duration // max time a trace is buffered waiting for its root span, StuckFunctionTimeout but at least 1m by default
+	TailMaxTraceSpans    int           // max buffered spans of a trace, it's decided early when reached, 1000 by default
+
+	SlowProfilingEnabled   bool          // whether to capture pprof CPU/heap profiles of slow and stuck functions, independent of the agent
+	SlowFunctionThreshold  time.Duration // function duration above which profiles are captured, 1s by default
+	SlowProfileDir         string        // where captured profiles are stored, "profiles" by default
+	SlowProfileMaxFiles    int           // how many captured profiles to keep, 100 by default, negative keeps all of them
+	SlowProfileInterval    time.Duration // minimum time between two captures across all functions, 1m by default
+	SlowProfileCPUDuration time.Duration // length of the CPU profile recorded after a slow call returned or while a call is stuck, 5s by default
+
+	PprofLabelsEnabled bool     // whether ReportFuncTimingCtx and ReportFuncCallAndTimingCtx run the caller under pprof labels
+	PprofLabelTags     []string // tag keys added as pprof labels next to func_name
+
+	LogTraceIDs bool // whether warnings and errors logged for timed functions (stuck functions, slow profiles, trace region snapshots) carry trace_id, span_id and context tags; agent write errors aren't tied to a call and never do
 }
 
 func (m *StatterConfig) BaseTags() []string {
+	return m.baseTagsFor(m.Agent)
+}
+
+// baseTagsFor returns base tags in the format of the agent, e.g. OTEL resource attributes for traces
+// are always built from the OTELAgent format, independent of the metrics agent.
+func (m *StatterConfig) baseTagsFor(agent string) []string {
 	defaultTags := Combine(m.DefaultTags...)
 	var baseTags []string
 
-	switch m.Agent {
+	switch agent {
 
 	case DatadogAgent:
 		if len(config.EnvName) > 0 {
@@ -73,7 +155,7 @@ func (m *StatterConfig) BaseTags() []string {
 		for k, v := range defaultTags {
 			baseTags = append(baseTags, k+":"+v)
 		}
-	case OTELAgent:
+	case OTELAgent, StdoutAgent, InfluxDBAgent, GraphiteAgent:
 		if len(config.EnvName) > 0 {
 			baseTags = append(baseTags, "env="+config.EnvName)
 		}
@@ -110,24 +192,37 @@ type Statter interface {
 }
 
 func Close() {
+	// shutdown funcs report through the package helpers, so they're called without holding the lock
 	clientMux.RLock()
-	defer clientMux.RUnlock()
+	traceShutdown, statter, logShutdown := traceProviderShutdownFn, client, logProviderShutdownFn
+	clientMux.RUnlock()
+
+	// traces first, their exporter may share output with the client
+	if traceShutdown != nil {
+		traceShutdown()
+	}
 
-	if client != nil {
-		client.Close()
+	if statter != nil {
+		statter.Close()
 	}
 
-	if traceProviderShutdownFn != nil {
-		traceProviderShutdownFn()
+	if logShutdown != nil {
+		logShutdown()
 	}
 }
 
 func Init(addr string, prefix string, cfg *StatterConfig) error {
-	config = checkConfig(cfg)
+	cfg = checkConfig(cfg)
+	clientMux.Lock()
+	config = cfg
+	clientMux.Unlock()
+	logTraceIDs.Store(cfg.LogTraceIDs)
+	setupSlowProfiler(config)
+
 	if config.MockingEnabled {
 		// init a mock statter instead of real statsd client
 		clientMux.Lock()
-		client = newMockStatter(cfg)
+		client = newSamplingStatter(newMockStatter(cfg), cfg.sampleRates(), false)
 		clientMux.Unlock()
 		return nil
 	}
@@ -144,15 +239,25 @@ func Init(addr string, prefix string, cfg *StatterConfig) error {
 			dogstatsd.WithNamespace(prefix),
 			dogstatsd.WithWriteTimeout(time.Duration(10)*time.Second),
 			dogstatsd.WithTags(config.BaseTags()),
+			datadogOriginDetection(cfg),
 		)
 
 	case TelegrafAgent:
+		if cfg.TelegrafAggregationEnabled {
+			statter, err = newAggregatingTelegrafStatter(addr, prefix, cfg, config.BaseTags())
+			break
+		}
+		maxPacketSize := cfg.TelegrafMaxPacketSize
+		if maxPacketSize <= 0 {
+			maxPacketSize = telegrafDefaultMaxPacketSize
+		}
 		statter, err = newTelegrafStatter(
-			statsd.Address(addr),
+			addr,
 			statsd.Prefix(prefix),
 			statsd.ErrorHandler(errHandler),
 			statsd.TagsFormat(statsd.InfluxDB),
 			statsd.Tags(config.BaseTags()...),
+			statsd.MaxPacketSize(maxPacketSize),
 		)
 
 	case OTELAgent:
@@ -164,6 +269,31 @@ func Init(addr string, prefix string, cfg *StatterConfig) error {
 			config.BaseTags(),
 		)
 
+	case StdoutAgent:
+		statter, err = newStdoutStatter(
+			addr,
+			prefix,
+			cfg.StdoutFormat,
+			cfg.StdoutFlushInterval,
+			config.BaseTags(),
+		)
+
+	case InfluxDBAgent:
+		statter, err = newInfluxStatter(
+			addr,
+			prefix,
+			cfg,
+			config.BaseTags(),
+		)
+
+	case GraphiteAgent:
+		statter, err = newGraphiteStatter(
+			addr,
+			prefix,
+			cfg,
+			config.BaseTags(),
+		)
+
 	default:
 		return ErrUnsupportedAgent
 	}
@@ -172,29 +302,36 @@ func Init(addr string, prefix string, cfg *StatterConfig) error {
 		err = errors.Wrap(err, "statsd init failed")
 		return err
 	}
+	// statsd clients sample by themselves, unless Telegraf calls are aggregated first
+	nativeSampling := cfg.Agent == DatadogAgent || (cfg.Agent == TelegrafAgent && !cfg.TelegrafAggregationEnabled)
+	statter = newSamplingStatter(statter, cfg.sampleRates(), nativeSampling)
+	if len(cfg.RecordFile) > 0 {
+		recorder, err := NewRecordingStatter(cfg.RecordFile, cfg.Agent)
+		if err != nil {
+			statter.Close()
+			return errors.Wrap(err, "recording statter init failed")
+		}
+		statter = &teeStatter{primary: statter, secondary: recorder}
+	}
 	clientMux.Lock()
 	client = statter
 	clientMux.Unlock()
 
-	// OpenTelemetry tracing via DataDog provider
-	if cfg.Agent == DatadogAgent && cfg.TracingEnabled {
-		traceProvider := ddotel.NewTracerProvider()
-		otel.SetTracerProvider(traceProvider)
-		tracer = otel.Tracer("")
-		traceProviderShutdownFn = traceProvider.Shutdown
-	} else if cfg.Agent == OTELAgent && cfg.TracingEnabled {
-		traceProvider, err := newOTELTracerProvider(addr, cfg.OTELInsecure, cfg.OTELHeaders, config.BaseTags())
+	if cfg.TracingEnabled {
+		if err := initTracing(addr, prefix, cfg); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Agent == OTELAgent && cfg.OTELLogsEnabled {
+		logProvider, err := newOTELLoggerProvider(addr, cfg.OTELInsecure, cfg.OTELHeaders, config.BaseTags(), cfg.OTELLogsMaxQueueSize)
 		if err != nil {
-			return errors.Wrap(err, "otel tracer provider init failed")
+			return errors.Wrap(err, "otel logger provider init failed")
 		}
-		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
-			propagation.TraceContext{},
-			propagation.Baggage{},
-		))
-		otel.SetTracerProvider(traceProvider)
-		tracer = otel.Tracer(prefix)
-		traceProviderShutdownFn = func() error {
-			return traceProvider.Shutdown(context.Background())
+		setupOTELLogs(logProvider, prefix)
+		logProviderShutdownFn = func() error {
+			otelLogs.setLogger(nil)
+			return logProvider.Shutdown(context.Background())
 		}
 	}
 
@@ -212,12 +349,202 @@ func Init(addr string, prefix string, cfg *StatterConfig) error {
 	return nil
 }
 
+// SetStatter installs s as the package-level statter and returns a function restoring the previous one.
+// If the package hasn't been initialized yet, the default config is set up as well. Mostly useful for tests,
+// see the metricstest package.
+func SetStatter(s Statter) (restore func()) {
+	clientMux.Lock()
+	defer clientMux.Unlock()
+
+	if config == nil {
+		config = checkConfig(nil)
+	}
+	prev := client
+	client = s
+
+	return func() {
+		clientMux.Lock()
+		defer clientMux.Unlock()
+		client = prev
+	}
+}
+
+// SetTracer replaces the tracer used for spans of timed functions and returns a function restoring the previous one.
+// Mostly useful for tests, see metricstest.RecordSpans.
+func SetTracer(t trace.Tracer) (restore func()) {
+	clientMux.Lock()
+	defer clientMux.Unlock()
+
+	prev := tracer
+	tracer = t
+
+	return func() {
+		clientMux.Lock()
+		defer clientMux.Unlock()
+		tracer = prev
+	}
+}
+
+// initTracing sets up the tracer provider of cfg.TraceExporter, which defaults to the exporter matching the metrics agent.
+func initTracing(addr, prefix string, cfg *StatterConfig) error {
+	switch cfg.traceExporter() {
+	case TraceExporterDatadog:
+		// OpenTelemetry tracing via DataDog provider
+		var opts []ddtracer.StartOption
+		if len(cfg.TraceEndpoint) > 0 {
+			opts = append(opts, ddtracer.WithAgentAddr(cfg.TraceEndpoint))
+		}
+		traceProvider := ddotel.NewTracerProvider(opts...)
+		otel.SetTextMapPropagator(newTextMapPropagator())
+		otel.SetTracerProvider(traceProvider)
+		tracer = otel.Tracer("")
+		traceProviderShutdownFn = traceProvider.Shutdown
+
+	case TraceExporterOTLP:
+		endpoint := addr
+		if len(cfg.TraceEndpoint) > 0 {
+			endpoint = cfg.TraceEndpoint
+		}
+		exp, err := newOTLPTraceExporter(endpoint, cfg)
+		if err != nil {
+			return errors.Wrap(err, "otel tracer provider init failed")
+		}
+		installOTELTracerProvider(exp, prefix, cfg)
+
+	case TraceExporterStdout:
+		exp, err := newStdoutTraceExporter(addr, cfg)
+		if err != nil {
+			return errors.Wrap(err, "stdout trace exporter init failed")
+		}
+		installOTELTracerProvider(exp, prefix, cfg, sdktrace.WithBatchTimeout(exp.out.flushInterval))
+
+	case "":
+		// e.g. Telegraf metrics without TraceExporter, nothing to export traces to
+
+	default:
+		return ErrUnsupportedTraceExporter
+	}
+	return nil
+}
+
+// installOTELTracerProvider sets up an SDK tracer provider exporting to exp, sampled according to the config.
+func installOTELTracerProvider(exp sdktrace.SpanExporter, prefix string, cfg *StatterConfig, batchOpts ...sdktrace.BatchSpanProcessorOption) {
+	sampler := newTraceSampler(cfg)
+	traceProvider := newOTELTracerProvider(exp, cfg, cfg.baseTagsFor(OTELAgent), sdktrace.ParentBased(sampler), batchOpts...)
+	stopSamplingReport := make(chan struct{})
+	stopSamplingReportOnce := sync.Once{}
+	if cfg.Agent != StdoutAgent {
+		// the stdout agent output is read by people, who see sampled spans there already
+		go sampler.reportLoop(stopSamplingReport)
+	}
+
+	otel.SetTextMapPropagator(newTextMapPropagator())
+	otel.SetTracerProvider(traceProvider)
+	tracer = otel.Tracer(prefix)
+	traceProviderShutdownFn = func() error {
+		stopSamplingReportOnce.Do(func() { close(stopSamplingReport) })
+		return traceProvider.Shutdown(context.Background())
+	}
+}
+
+// newStdoutTraceExporter writes span trees to TraceEndpoint, or next to the metrics of the stdout agent.
+func newStdoutTraceExporter(addr string, cfg *StatterConfig) (*stdoutSpanExporter, error) {
+	path := cfg.TraceEndpoint
+	if len(path) == 0 && cfg.Agent == StdoutAgent {
+		path = addr
+	}
+
+	clientMux.RLock()
+	statter, ok := unwrapStatter(client).(*stdoutStatter)
+	clientMux.RUnlock()
+	if ok && statter.out.path == path {
+		return newStdoutSpanExporter(statter.out, cfg.StdoutFormat, false), nil
+	}
+
+	out, err := newStdoutWriter(path, cfg.StdoutFlushInterval)
+	if err != nil {
+		return nil, err
+	}
+	return newStdoutSpanExporter(out, cfg.StdoutFormat, true), nil
+}
+
+// traceExporter returns TraceExporter, or the exporter matching the metrics agent if it's not set.
+func (m *StatterConfig) traceExporter() string {
+	if len(m.TraceExporter) > 0 {
+		return m.TraceExporter
+	}
+	switch m.Agent {
+	case DatadogAgent:
+		return TraceExporterDatadog
+	case OTELAgent:
+		return TraceExporterOTLP
+	case StdoutAgent:
+		return TraceExporterStdout
+	}
+	return ""
+}
+
+// traceInsecure and traceHeaders fall back to the OTEL metrics settings when traces go to the metrics address.
+func (m *StatterConfig) traceInsecure() bool {
+	if len(m.TraceEndpoint) == 0 {
+		return m.TraceInsecure || m.OTELInsecure
+	}
+	return m.TraceInsecure
+}
+
+// traceTLSConfig returns the TLS config of otlp trace exports, nil if none of the TraceTLS* options is set.
+func (m *StatterConfig) traceTLSConfig() (*tls.Config, error) {
+	if len(m.TraceTLSCAFile)+len(m.TraceTLSCertFile)+len(m.TraceTLSKeyFile)+len(m.TraceTLSServerName) == 0 {
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{
+		ServerName: m.TraceTLSServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(m.TraceTLSCAFile) > 0 {
+		pem, err := os.ReadFile(m.TraceTLSCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read trace TLS CA file")
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in trace TLS CA file %s", m.TraceTLSCAFile)
+		}
+	}
+	if len(m.TraceTLSCertFile) > 0 || len(m.TraceTLSKeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(m.TraceTLSCertFile, m.TraceTLSKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load trace TLS client certificate")
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+func (m *StatterConfig) traceHeaders() map[string]string {
+	if len(m.TraceEndpoint) == 0 && len(m.TraceHeaders) == 0 {
+		return m.OTELHeaders
+	}
+	return m.TraceHeaders
+}
+
 func StartMixPanel(projectToken string) {
 	clientMux.Lock()
 	defer clientMux.Unlock()
 	mixPanelClient = mixpanel.NewApiClient(projectToken)
 }
 
+func setupSlowProfiler(cfg *StatterConfig) {
+	clientMux.Lock()
+	defer clientMux.Unlock()
+
+	localProfiler = nil
+	if cfg.SlowProfilingEnabled {
+		localProfiler = newSlowProfiler(cfg)
+	}
+}
+
 func setupProfiler(cfg *StatterConfig) error {
 	runtime.SetMutexProfileFraction(5)
 	runtime.SetBlockProfileRate(5)
@@ -240,6 +567,14 @@ func setupProfiler(cfg *StatterConfig) error {
 	return nil
 }
 
+// datadogOriginDetection returns the dogstatsd option for OriginDetectionDisabled, origin detection is on by default.
+func datadogOriginDetection(cfg *StatterConfig) dogstatsd.Option {
+	if cfg.OriginDetectionDisabled {
+		return dogstatsd.WithoutOriginDetection()
+	}
+	return dogstatsd.WithOriginDetection()
+}
+
 func checkConfig(cfg *StatterConfig) *StatterConfig {
 	if cfg == nil {
 		cfg = &StatterConfig{}
@@ -1,9 +1,28 @@
 package metrics
 
 import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	dogstatsd "github.com/DataDog/datadog-go/v5/statsd"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBaseTags(t *testing.T) {
@@ -79,3 +98,155 @@ func TestBaseTags(t *testing.T) {
 		})
 	}
 }
+
+func TestTraceExporterConfig(t *testing.T) {
+	cfg := &StatterConfig{Agent: OTELAgent, OTELInsecure: true, OTELHeaders: map[string]string{"token": "metrics"}}
+	assert.Equal(t, TraceExporterOTLP, cfg.traceExporter())
+	assert.True(t, cfg.traceInsecure())
+	assert.Equal(t, map[string]string{"token": "metrics"}, cfg.traceHeaders())
+
+	cfg.TraceEndpoint = "collector:4317"
+	assert.False(t, cfg.traceInsecure(), "a separate trace endpoint doesn't inherit metrics TLS settings")
+	assert.Empty(t, cfg.traceHeaders())
+
+	cfg = &StatterConfig{Agent: TelegrafAgent}
+	assert.Empty(t, cfg.traceExporter())
+	cfg.TraceExporter = TraceExporterDatadog
+	assert.Equal(t, TraceExporterDatadog, cfg.traceExporter())
+	assert.Equal(t, ErrUnsupportedTraceExporter, initTracing("", "", &StatterConfig{TraceExporter: "zipkin"}))
+}
+
+func TestDatadogOriginDetection(t *testing.T) {
+	// options are closures, the ones returned by the same dogstatsd func share their code pointer
+	option := func(opt dogstatsd.Option) uintptr {
+		return reflect.ValueOf(opt).Pointer()
+	}
+	require.NotEqual(t, option(dogstatsd.WithOriginDetection()), option(dogstatsd.WithoutOriginDetection()))
+	assert.Equal(t, option(dogstatsd.WithOriginDetection()), option(datadogOriginDetection(&StatterConfig{})))
+	assert.Equal(t, option(dogstatsd.WithoutOriginDetection()), option(datadogOriginDetection(&StatterConfig{OriginDetectionDisabled: true})))
+}
+
+func initUnixAgent(t *testing.T, agent, addr string) error {
+	t.Helper()
+	restoreStatter := SetStatter(nil)
+	t.Cleanup(restoreStatter)
+
+	return Init(addr, "svc.", &StatterConfig{Agent: agent, EnvName: "test"})
+}
+
+func TestUnixSocketAddr(t *testing.T) {
+	readDatagram := func(t *testing.T, conn net.PacketConn) string {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		buf := make([]byte, 64*1024)
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+
+	for _, tt := range []struct {
+		agent    string
+		scheme   string
+		expected string
+	}{
+		{DatadogAgent, "unix://", "svc.orders:1|c|#env:test,market:INJ/USDT"},
+		{DatadogAgent, "unixgram://", "svc.orders:1|c|#env:test,market:INJ/USDT"},
+		{TelegrafAgent, "unix://", "svc.orders,market=INJ/USDT,env=test:1|c"},
+	} {
+		t.Run(tt.agent+" "+tt.scheme, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "agent.sock")
+			conn, err := net.ListenPacket("unixgram", path)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			require.NoError(t, initUnixAgent(t, tt.agent, tt.scheme+path))
+			Counter("orders", 1, "market", "INJ/USDT")
+			Close()
+
+			assert.Contains(t, readDatagram(t, conn), tt.expected)
+		})
+	}
+
+	t.Run("datadog unixstream://", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "agent.sock")
+		l, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		defer l.Close()
+
+		lines := make(chan string, 10)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				// dogstatsd frames every payload with its length on stream sockets
+				var size [4]byte
+				if _, err := io.ReadFull(r, size[:]); err != nil {
+					return
+				}
+				payload := make([]byte, binary.LittleEndian.Uint32(size[:]))
+				if _, err := io.ReadFull(r, payload); err != nil {
+					return
+				}
+				for _, line := range strings.Split(strings.TrimSpace(string(payload)), "\n") {
+					lines <- line
+				}
+			}
+		}()
+
+		require.NoError(t, initUnixAgent(t, DatadogAgent, "unixstream://"+path))
+		Counter("orders", 1, "market", "INJ/USDT")
+		Close()
+
+		select {
+		case line := <-lines:
+			assert.Contains(t, line, "svc.orders:1|c|#env:test,market:INJ/USDT")
+		case <-time.After(5 * time.Second):
+			t.Fatal("nothing received over the stream socket")
+		}
+	})
+
+	t.Run("telegraf unixstream://", func(t *testing.T) {
+		err := initUnixAgent(t, TelegrafAgent, "unixstream://"+filepath.Join(t.TempDir(), "agent.sock"))
+		assert.True(t, errors.Is(err, ErrUnsupportedAddr), err)
+	})
+}
+
+func TestTraceTLSConfig(t *testing.T) {
+	tlsCfg, err := (&StatterConfig{}).traceTLSConfig()
+	require.NoError(t, err)
+	assert.Nil(t, tlsCfg, "exports use the default TLS config without TraceTLS options")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "collector"},
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	tlsCfg, err = (&StatterConfig{
+		TraceTLSCAFile:     certFile,
+		TraceTLSCertFile:   certFile,
+		TraceTLSKeyFile:    keyFile,
+		TraceTLSServerName: "collector",
+	}).traceTLSConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "collector", tlsCfg.ServerName)
+	assert.NotNil(t, tlsCfg.RootCAs)
+	assert.Len(t, tlsCfg.Certificates, 1)
+
+	_, err = (&StatterConfig{TraceTLSCAFile: keyFile}).traceTLSConfig()
+	assert.ErrorContains(t, err, "no certificates found")
+}
@@ -0,0 +1,73 @@
+// Command metrics-replay loads a JSONL recording made with StatterConfig.RecordFile into a metrics agent.
+//
+//	metrics-replay -file testnet.jsonl -agent datadog -addr localhost:8125 -prefix injective.exchange. -env testnet
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	log "github.com/InjectiveLabs/suplog"
+
+	"github.com/InjectiveLabs/metrics"
+)
+
+// agents are the metrics.StatterConfig agents a recording can be replayed into.
+var agents = []string{
+	metrics.TelegrafAgent,
+	metrics.DatadogAgent,
+	metrics.OTELAgent,
+	metrics.StdoutAgent,
+	metrics.InfluxDBAgent,
+	metrics.GraphiteAgent,
+}
+
+func main() {
+	var (
+		file     = flag.String("file", "", "JSONL recording to replay")
+		agent    = flag.String("agent", metrics.TelegrafAgent, strings.Join(agents, "/"))
+		addr     = flag.String("addr", "localhost:8125", "agent address")
+		prefix   = flag.String("prefix", "", "metrics prefix")
+		env      = flag.String("env", "", "env tag of replayed metrics")
+		host     = flag.String("host", "", "machine tag of replayed metrics")
+		insecure = flag.Bool("otel-insecure", false, "disable TLS for the otel agent")
+		realtime = flag.Bool("realtime", false, "replay at the original pace instead of as fast as possible")
+	)
+	flag.Parse()
+
+	if *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to open recording")
+	}
+	defer f.Close()
+
+	err = metrics.Init(*addr, *prefix, &metrics.StatterConfig{
+		Agent:        *agent,
+		EnvName:      *env,
+		HostName:     *host,
+		OTELInsecure: *insecure,
+	})
+	if err != nil {
+		log.WithError(err).Fatalln("failed to init metrics")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	replayed, err := metrics.Replay(ctx, f, *realtime)
+	metrics.Close()
+	if err != nil {
+		log.WithError(err).Errorf("replay stopped after %d calls", replayed)
+		os.Exit(1)
+	}
+	log.Infof("replayed %d calls from %s", replayed, *file)
+}
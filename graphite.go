@@ -0,0 +1,332 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/InjectiveLabs/suplog"
+)
+
+const (
+	graphiteTimeout              = 5 * time.Second
+	graphiteDefaultReservoirSize = 1024
+)
+
+var graphiteDefaultPercentiles = []float64{50, 95, 99}
+
+var (
+	graphitePathReplacer = strings.NewReplacer(" ", "_", "/", "_")
+	graphiteNodeReplacer = strings.NewReplacer(" ", "_", "/", "_", ".", "_")
+	graphiteTagReplacer  = strings.NewReplacer(";", "_", " ", "_", "~", "_")
+)
+
+// graphiteKey is a series of the Graphite agent, suffixes like .count go between path and tags.
+type graphiteKey struct {
+	path string
+	tags string // ";k=v" pairs sorted by key, empty when tags are folded into the path
+}
+
+func (k graphiteKey) name(suffix string) string {
+	return k.path + suffix + k.tags
+}
+
+// graphiteStatter writes to carbon over the plaintext TCP protocol. Calls are aggregated per flush interval,
+// counts are summed, the last gauge value is kept, and timings and histograms are sent as count, min, max,
+// mean and percentiles, the percentiles computed over a bounded reservoir of the values. Series are tagged as
+// name;k=v, or with fold tags configured, the values of those tags are appended to the dotted path and other tags
+// are dropped.
+type graphiteStatter struct {
+	addr          string
+	prefix        string
+	baseTags      []string
+	foldTags      []string
+	percentiles   []float64
+	reservoirSize int
+
+	mu       sync.Mutex
+	counters map[graphiteKey]int64
+	gauges   map[graphiteKey]float64
+	samples  map[graphiteKey]*graphiteSamples
+
+	connMu sync.Mutex
+	conn   net.Conn
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// graphiteSamples are the timings or histogram values of a series since the last flush.
+type graphiteSamples struct {
+	reservoir
+	min, max, sum float64
+}
+
+// newGraphiteStatter connects lazily, so carbon being down doesn't fail Init, and reconnects on write errors.
+func newGraphiteStatter(addr, prefix string, cfg *StatterConfig, baseTags []string) (Statter, error) {
+	s := &graphiteStatter{
+		addr:          addr,
+		prefix:        prefix,
+		baseTags:      baseTags,
+		foldTags:      cfg.GraphiteFoldTags,
+		percentiles:   cfg.GraphitePercentiles,
+		reservoirSize: cfg.GraphiteReservoirSize,
+		counters:      make(map[graphiteKey]int64),
+		gauges:        make(map[graphiteKey]float64),
+		samples:       make(map[graphiteKey]*graphiteSamples),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	if len(s.percentiles) == 0 {
+		s.percentiles = graphiteDefaultPercentiles
+	}
+	if s.reservoirSize <= 0 {
+		s.reservoirSize = graphiteDefaultReservoirSize
+	}
+	interval := cfg.GraphiteFlushInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	go s.flushLoop(interval)
+	return s, nil
+}
+
+func (s *graphiteStatter) key(name string, tags []string) graphiteKey {
+	k := graphiteKey{path: graphitePathReplacer.Replace(s.prefix + name)}
+	pairs := parseTagPairs(s.baseTags, tags)
+
+	if len(s.foldTags) > 0 {
+		for _, tag := range s.foldTags {
+			if v := pairs[tag]; len(v) > 0 {
+				k.path += "." + graphiteNodeReplacer.Replace(v)
+			}
+		}
+		return k
+	}
+
+	keys := make([]string, 0, len(pairs))
+	for tag, v := range pairs {
+		if len(v) > 0 {
+			keys = append(keys, tag)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, tag := range keys {
+		b.WriteByte(';')
+		b.WriteString(graphiteTagReplacer.Replace(tag))
+		b.WriteByte('=')
+		b.WriteString(graphiteTagReplacer.Replace(pairs[tag]))
+	}
+	k.tags = b.String()
+	return k
+}
+
+func (s *graphiteStatter) count(name string, value int64, tags []string) {
+	k := s.key(name, tags)
+	s.mu.Lock()
+	s.counters[k] += value
+	s.mu.Unlock()
+}
+
+// sample keeps value for the flush, values sampled at rate count as 1/rate calls.
+func (s *graphiteStatter) sample(name string, value float64, tags []string, rate float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	k := s.key(name, tags)
+	s.mu.Lock()
+	samples, ok := s.samples[k]
+	if !ok {
+		samples = &graphiteSamples{
+			reservoir: reservoir{values: make([]float64, 0, s.reservoirSize)},
+			min:       value,
+			max:       value,
+		}
+		s.samples[k] = samples
+	}
+	samples.add(value, rate)
+	samples.min = math.Min(samples.min, value)
+	samples.max = math.Max(samples.max, value)
+	samples.sum += value
+	s.mu.Unlock()
+}
+
+func (s *graphiteStatter) Count(name string, value int64, tags []string, rate float64) error {
+	s.count(name, value, tags)
+	return nil
+}
+
+func (s *graphiteStatter) Incr(name string, tags []string, rate float64) error {
+	s.count(name, 1, tags)
+	return nil
+}
+
+func (s *graphiteStatter) Decr(name string, tags []string, rate float64) error {
+	s.count(name, -1, tags)
+	return nil
+}
+
+func (s *graphiteStatter) Gauge(name string, value float64, tags []string, rate float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	k := s.key(name, tags)
+	s.mu.Lock()
+	s.gauges[k] = value
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *graphiteStatter) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	s.sample(name, float64(value)/float64(time.Millisecond), tags, rate)
+	return nil
+}
+
+func (s *graphiteStatter) Histogram(name string, value float64, tags []string, rate float64) error {
+	s.sample(name, value, tags, rate)
+	return nil
+}
+
+func (s *graphiteStatter) flushLoop(interval time.Duration) {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			s.flush(time.Now())
+			return
+		case now := <-ticker.C:
+			s.flush(now)
+		}
+	}
+}
+
+// flush sends everything aggregated since the previous flush, points are dropped if carbon can't be reached.
+func (s *graphiteStatter) flush(now time.Time) {
+	s.mu.Lock()
+	counters, gauges, samples := s.counters, s.gauges, s.samples
+	s.counters = make(map[graphiteKey]int64, len(counters))
+	s.gauges = make(map[graphiteKey]float64, len(gauges))
+	s.samples = make(map[graphiteKey]*graphiteSamples, len(samples))
+	s.mu.Unlock()
+
+	if len(counters)+len(gauges)+len(samples) == 0 {
+		return
+	}
+
+	ts := strconv.FormatInt(now.Unix(), 10)
+	var buf bytes.Buffer
+	writeLine := func(name string, value float64) {
+		buf.WriteString(name)
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(ts)
+		buf.WriteByte('\n')
+	}
+
+	for k, v := range counters {
+		writeLine(k.name(""), float64(v))
+	}
+	for k, v := range gauges {
+		writeLine(k.name(""), v)
+	}
+	for k, samples := range samples {
+		values := samples.values
+		sort.Float64s(values)
+		writeLine(k.name(".count"), math.Round(samples.total))
+		writeLine(k.name(".min"), samples.min)
+		writeLine(k.name(".max"), samples.max)
+		writeLine(k.name(".mean"), samples.sum/float64(samples.seen))
+		for _, p := range s.percentiles {
+			writeLine(k.name(".p"+graphitePercentileSuffix(p)), percentile(values, p))
+		}
+	}
+
+	if err := s.send(buf.Bytes()); err != nil {
+		log.WithError(err).Errorln("graphite write failed")
+	}
+}
+
+// graphitePercentileSuffix formats 99.9 as 99_9, so it doesn't add a path node.
+func graphitePercentileSuffix(p float64) string {
+	return strings.ReplaceAll(strconv.FormatFloat(p, 'f', -1, 64), ".", "_")
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// send writes payload, reconnecting once if the connection was closed by carbon or the write fails.
+func (s *graphiteStatter) send(payload []byte) error {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn != nil && !graphiteConnAlive(s.conn) {
+			s.conn.Close()
+			s.conn = nil
+		}
+		if s.conn == nil {
+			if s.conn, err = net.DialTimeout("tcp", s.addr, graphiteTimeout); err != nil {
+				s.conn = nil
+				return err
+			}
+		}
+
+		_ = s.conn.SetWriteDeadline(time.Now().Add(graphiteTimeout))
+		if _, err = s.conn.Write(payload); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+// graphiteConnAlive detects connections closed by carbon, which never writes to clients,
+// since a write to such a connection usually succeeds and the points are silently lost.
+func graphiteConnAlive(conn net.Conn) bool {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// Close flushes aggregated points and closes the connection.
+func (s *graphiteStatter) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		<-s.stopped
+
+		s.connMu.Lock()
+		defer s.connMu.Unlock()
+		if s.conn != nil {
+			err = s.conn.Close()
+			s.conn = nil
+		}
+	})
+	return err
+}
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type carbonLine struct {
+	conn int
+	line string
+}
+
+// listenCarbon accepts plaintext connections and returns received lines with the index of their connection.
+func listenCarbon(t *testing.T) (addr string, lines chan carbonLine, conns chan net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	lines = make(chan carbonLine, 100)
+	conns = make(chan net.Conn, 10)
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go func(i int) {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- carbonLine{conn: i, line: scanner.Text()}
+				}
+			}(i)
+		}
+	}()
+	return l.Addr().String(), lines, conns
+}
+
+func readCarbonLines(t *testing.T, lines chan carbonLine, n int) []carbonLine {
+	t.Helper()
+	var res []carbonLine
+	for len(res) < n {
+		select {
+		case line := <-lines:
+			res = append(res, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d lines: %v", len(res), n, res)
+		}
+	}
+	return res
+}
+
+func TestGraphiteTaggedAggregation(t *testing.T) {
+	addr, lines, _ := listenCarbon(t)
+	s, err := newGraphiteStatter(addr, "exchange.", &StatterConfig{
+		GraphitePercentiles:   []float64{50, 99.9},
+		GraphiteFlushInterval: time.Hour,
+	}, []string{"env=test"})
+	require.NoError(t, err)
+	defer s.Close()
+
+	tags := []string{"market=INJ/USDT", "side=buy;sell"}
+	s.Count("orders", 3, tags, 1)
+	s.Incr("orders", tags, 1)
+	s.Gauge("depth", 1, tags, 1)
+	s.Gauge("depth", 2, tags, 1)
+	for i := 1; i <= 100; i++ {
+		s.Timing("func.timing", time.Duration(i)*time.Millisecond, nil, 1)
+	}
+	s.(*graphiteStatter).flush(time.Unix(1700000000, 0))
+
+	var got []string
+	for _, l := range readCarbonLines(t, lines, 8) {
+		got = append(got, l.line)
+	}
+	assert.ElementsMatch(t, []string{
+		"exchange.orders;env=test;market=INJ/USDT;side=buy_sell 4 1700000000",
+		"exchange.depth;env=test;market=INJ/USDT;side=buy_sell 2 1700000000",
+		"exchange.func.timing.count;env=test 100 1700000000",
+		"exchange.func.timing.min;env=test 1 1700000000",
+		"exchange.func.timing.max;env=test 100 1700000000",
+		"exchange.func.timing.mean;env=test 50.5 1700000000",
+		"exchange.func.timing.p50;env=test 50 1700000000",
+		"exchange.func.timing.p99_9;env=test 100 1700000000",
+	}, got)
+}
+
+func TestGraphiteFoldTagsAndReconnect(t *testing.T) {
+	addr, lines, conns := listenCarbon(t)
+	s, err := newGraphiteStatter(addr, "exchange.", &StatterConfig{
+		GraphiteFoldTags:      []string{"market", "side"},
+		GraphiteFlushInterval: time.Hour,
+	}, []string{"env=test"})
+	require.NoError(t, err)
+	defer s.Close()
+
+	s.Incr("orders", []string{"market=INJ/USDT", "side=buy", "ignored=1"}, 1)
+	s.(*graphiteStatter).flush(time.Unix(1700000000, 0))
+	first := readCarbonLines(t, lines, 1)[0]
+	assert.Equal(t, carbonLine{conn: 0, line: "exchange.orders.INJ_USDT.buy 1 1700000000"}, first)
+
+	// carbon restarts
+	(<-conns).Close()
+
+	s.Incr("orders", []string{"market=ATOM/USDT"}, 1)
+	s.(*graphiteStatter).flush(time.Unix(1700000010, 0))
+	second := readCarbonLines(t, lines, 1)[0]
+	assert.Equal(t, carbonLine{conn: 1, line: "exchange.orders.ATOM_USDT 1 1700000010"}, second)
+}
+
+func TestGraphiteReservoir(t *testing.T) {
+	addr, lines, _ := listenCarbon(t)
+	s, err := newGraphiteStatter(addr, "", &StatterConfig{
+		GraphitePercentiles:   []float64{50},
+		GraphiteFlushInterval: time.Hour,
+		GraphiteReservoirSize: 10,
+	}, nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	for i := 1; i <= 1000; i++ {
+		s.Histogram("fill", float64(i), nil, 1)
+	}
+	// sampled values count for the calls dropped by their rate
+	s.Histogram("fill", 500, nil, 0.5)
+	assert.Len(t, s.(*graphiteStatter).samples[graphiteKey{path: "fill"}].values, 10)
+	s.(*graphiteStatter).flush(time.Unix(1700000000, 0))
+
+	got := make(map[string]string)
+	for _, l := range readCarbonLines(t, lines, 5) {
+		name, value, _ := strings.Cut(l.line, " ")
+		got[name] = strings.TrimSuffix(value, " 1700000000")
+	}
+	assert.Equal(t, "1002", got["fill.count"])
+	assert.Equal(t, "1", got["fill.min"])
+	assert.Equal(t, "1000", got["fill.max"])
+	assert.Contains(t, got, "fill.p50")
+}
@@ -0,0 +1,356 @@
+package metrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/InjectiveLabs/suplog"
+)
+
+const (
+	influxDefaultBatchSize  = 5000
+	influxMaxPendingBatches = 16
+	// fits a single datagram into a 1500 bytes MTU with IPv6 and UDP headers
+	influxUDPMaxPayload = 1432
+)
+
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+var influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+
+// influxWriter sends a batch of newline-terminated line protocol points.
+type influxWriter interface {
+	write(batch []byte) error
+	Close() error
+}
+
+// influxStatter writes InfluxDB line protocol points with nanosecond timestamps. Counts are integer fields,
+// gauges, histograms and timings in milliseconds are float fields, all of them named value. Timings and histograms
+// kept by SampleRates also have a sample_rate field, so queries can weight them by 1/sample_rate. Points are
+// batched and written by a background goroutine, so Statter calls never wait for the network.
+type influxStatter struct {
+	out       influxWriter
+	prefix    string
+	baseTags  []string
+	batchSize int
+	interval  time.Duration
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+	n   int
+
+	batches   chan []byte
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// newInfluxStatter writes to addr over HTTP /api/v2/write if it's an http:// or https:// URL,
+// and over UDP otherwise, e.g. udp://localhost:8089 or localhost:8089.
+func newInfluxStatter(addr, prefix string, cfg *StatterConfig, baseTags []string) (Statter, error) {
+	var (
+		out influxWriter
+		err error
+	)
+	switch {
+	case strings.HasPrefix(addr, "http://"), strings.HasPrefix(addr, "https://"):
+		out, err = newInfluxHTTPWriter(addr, cfg.InfluxOrg, cfg.InfluxBucket, cfg.InfluxToken)
+	default:
+		out, err = newInfluxUDPWriter(strings.TrimPrefix(addr, "udp://"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &influxStatter{
+		out:       out,
+		prefix:    prefix,
+		baseTags:  baseTags,
+		batchSize: cfg.InfluxBatchSize,
+		interval:  cfg.InfluxFlushInterval,
+		batches:   make(chan []byte, influxMaxPendingBatches),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = influxDefaultBatchSize
+	}
+	if s.interval <= 0 {
+		s.interval = time.Second
+	}
+
+	go s.writeLoop()
+	return s, nil
+}
+
+func (s *influxStatter) add(name, field string, tags []string) {
+	line := s.appendPoint(nil, name, field, tags, time.Now())
+
+	s.mu.Lock()
+	s.buf.Write(line)
+	s.n++
+	var batch []byte
+	if s.n >= s.batchSize {
+		batch = s.takeLocked()
+	}
+	s.mu.Unlock()
+
+	if batch != nil {
+		select {
+		case s.batches <- batch:
+		default:
+			log.Warningf("influxdb writes are falling behind, dropped %d bytes of points", len(batch))
+		}
+	}
+}
+
+// appendPoint appends a line protocol point, tags sorted by key as recommended by InfluxDB.
+func (s *influxStatter) appendPoint(dst []byte, name, field string, tags []string, ts time.Time) []byte {
+	dst = append(dst, influxMeasurementEscaper.Replace(s.prefix+name)...)
+
+	pairs := parseTagPairs(s.baseTags, tags)
+	keys := make([]string, 0, len(pairs))
+	for k, v := range pairs {
+		if len(v) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		dst = append(dst, ',')
+		dst = append(dst, influxEscaper.Replace(k)...)
+		dst = append(dst, '=')
+		dst = append(dst, influxEscaper.Replace(pairs[k])...)
+	}
+
+	dst = append(dst, " value="...)
+	dst = append(dst, field...)
+	dst = append(dst, ' ')
+	dst = strconv.AppendInt(dst, ts.UnixNano(), 10)
+	return append(dst, '\n')
+}
+
+func (s *influxStatter) takeLocked() []byte {
+	if s.n == 0 {
+		return nil
+	}
+	batch := bytes.Clone(s.buf.Bytes())
+	s.buf.Reset()
+	s.n = 0
+	return batch
+}
+
+func (s *influxStatter) take() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.takeLocked()
+}
+
+func (s *influxStatter) writeLoop() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case batch := <-s.batches:
+			s.write(batch)
+		case <-ticker.C:
+			s.write(s.take())
+		case <-s.done:
+			for {
+				select {
+				case batch := <-s.batches:
+					s.write(batch)
+				default:
+					s.write(s.take())
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *influxStatter) write(batch []byte) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := s.out.write(batch); err != nil {
+		log.WithError(err).Errorln("influxdb write failed")
+	}
+}
+
+func influxFloat(v float64) (string, bool) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		// not representable in line protocol
+		return "", false
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64), true
+}
+
+func (s *influxStatter) Count(name string, value int64, tags []string, rate float64) error {
+	s.add(name, strconv.FormatInt(value, 10)+"i", tags)
+	return nil
+}
+
+func (s *influxStatter) Incr(name string, tags []string, rate float64) error {
+	s.add(name, "1i", tags)
+	return nil
+}
+
+func (s *influxStatter) Decr(name string, tags []string, rate float64) error {
+	s.add(name, "-1i", tags)
+	return nil
+}
+
+func (s *influxStatter) Gauge(name string, value float64, tags []string, rate float64) error {
+	if field, ok := influxFloat(value); ok {
+		s.add(name, field, tags)
+	}
+	return nil
+}
+
+func (s *influxStatter) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	field, _ := influxFloat(float64(value) / float64(time.Millisecond))
+	s.add(name, influxSampled(field, rate), tags)
+	return nil
+}
+
+func (s *influxStatter) Histogram(name string, value float64, tags []string, rate float64) error {
+	if field, ok := influxFloat(value); ok {
+		s.add(name, influxSampled(field, rate), tags)
+	}
+	return nil
+}
+
+// influxSampled appends the sample_rate field to the value field of a sampled point.
+func influxSampled(field string, rate float64) string {
+	if rate <= 0 || rate >= 1 {
+		return field
+	}
+	return field + ",sample_rate=" + strconv.FormatFloat(rate, 'f', -1, 64)
+}
+
+// Close writes buffered points and closes the connection.
+func (s *influxStatter) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		<-s.stopped
+		err = s.out.Close()
+	})
+	return err
+}
+
+type influxUDPWriter struct {
+	conn net.Conn
+}
+
+func newInfluxUDPWriter(addr string) (*influxUDPWriter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &influxUDPWriter{conn: conn}, nil
+}
+
+// write splits the batch into datagrams on line boundaries, a line longer than
+// influxUDPMaxPayload is sent on its own.
+func (w *influxUDPWriter) write(batch []byte) error {
+	var firstErr error
+	for len(batch) > 0 {
+		end := len(batch)
+		if end > influxUDPMaxPayload {
+			end = bytes.LastIndexByte(batch[:influxUDPMaxPayload], '\n') + 1
+			if end == 0 {
+				end = bytes.IndexByte(batch, '\n') + 1
+			}
+		}
+		if _, err := w.conn.Write(batch[:end]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		batch = batch[end:]
+	}
+	return firstErr
+}
+
+func (w *influxUDPWriter) Close() error {
+	return w.conn.Close()
+}
+
+type influxHTTPWriter struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+func newInfluxHTTPWriter(addr, org, bucket, token string) (*influxHTTPWriter, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid influxdb url")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+	q := u.Query()
+	q.Set("org", org)
+	q.Set("bucket", bucket)
+	q.Set("precision", "ns")
+	u.RawQuery = q.Encode()
+
+	return &influxHTTPWriter{
+		client: &http.Client{Timeout: 10 * time.Second},
+		url:    u.String(),
+		token:  token,
+	}, nil
+}
+
+func (w *influxHTTPWriter) write(batch []byte) error {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	if _, err := zw.Write(batch); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Content-Encoding", "gzip")
+	if len(w.token) > 0 {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("influxdb write returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (w *influxHTTPWriter) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxHTTPStatter(t *testing.T) {
+	bodies := make(chan string, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/write", r.URL.Path)
+		assert.Equal(t, "injective", r.URL.Query().Get("org"))
+		assert.Equal(t, "metrics", r.URL.Query().Get("bucket"))
+		assert.Equal(t, "ns", r.URL.Query().Get("precision"))
+		assert.Equal(t, "Token secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+
+		zr, err := gzip.NewReader(r.Body)
+		if !assert.NoError(t, err) {
+			return
+		}
+		body, err := io.ReadAll(zr)
+		assert.NoError(t, err)
+		bodies <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s, err := newInfluxStatter(srv.URL, "exchange.", &StatterConfig{
+		InfluxOrg:           "injective",
+		InfluxBucket:        "metrics",
+		InfluxToken:         "secret",
+		InfluxBatchSize:     3,
+		InfluxFlushInterval: time.Hour,
+	}, []string{"env=test"})
+	require.NoError(t, err)
+
+	before := time.Now().UnixNano()
+	tags := []string{"market=INJ/USDT", "side=buy sell", "empty="}
+	require.NoError(t, s.Count("orders", 3, tags, 1))
+	require.NoError(t, s.Gauge("depth", 12.5, tags, 1))
+	require.NoError(t, s.Timing("func.timing", 1500*time.Microsecond, tags, 1))
+	require.NoError(t, s.Incr("matched", nil, 1))
+
+	var lines []string
+	select {
+	case body := <-bodies:
+		lines = strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch wasn't written once full")
+	}
+	require.Len(t, lines, 3)
+
+	prefixes := []string{
+		`exchange.orders,env=test,market=INJ/USDT,side=buy\ sell value=3i `,
+		`exchange.depth,env=test,market=INJ/USDT,side=buy\ sell value=12.5 `,
+		`exchange.func.timing,env=test,market=INJ/USDT,side=buy\ sell value=1.5 `,
+	}
+	for i, line := range lines {
+		require.True(t, strings.HasPrefix(line, prefixes[i]), line)
+		var ts int64
+		_, err := fmt.Sscan(strings.TrimPrefix(line, prefixes[i]), &ts)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, ts, before)
+	}
+
+	// the rest is written on close
+	require.NoError(t, s.Close())
+	select {
+	case body := <-bodies:
+		assert.True(t, strings.HasPrefix(body, "exchange.matched,env=test value=1i "), body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("buffered points weren't written on close")
+	}
+}
+
+func TestInfluxUDPWriterSplitsDatagrams(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	w, err := newInfluxUDPWriter(conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer w.Close()
+
+	line := "m,k=" + strings.Repeat("v", 100) + " value=1i 1\n"
+	batch := strings.Repeat(line, 30)
+	require.NoError(t, w.write([]byte(batch)))
+
+	var received strings.Builder
+	buf := make([]byte, 64*1024)
+	for received.Len() < len(batch) {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, n, influxUDPMaxPayload)
+		assert.True(t, strings.HasSuffix(string(buf[:n]), "\n"))
+		received.Write(buf[:n])
+	}
+	assert.Equal(t, batch, received.String())
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"context"
+	"sync/atomic"
+
+	log "github.com/InjectiveLabs/suplog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type ctxTagsKey struct{}
+
+// withContextTags returns ctx carrying tags merged over the tags already in ctx.
+func withContextTags(ctx context.Context, tags Tags) context.Context {
+	if len(tags) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxTagsKey{}, MergeTags(TagsFromContext(ctx), tags))
+}
+
+// TagsFromContext returns the tags of the innermost timed function of ctx, merged with tags of its callers.
+func TagsFromContext(ctx context.Context) Tags {
+	tags, _ := ctx.Value(ctxTagsKey{}).(Tags)
+	return tags
+}
+
+// Logger returns the default suplog logger with the trace_id and span_id of the span in ctx
+// and the tags of timed functions in ctx, e.g. started with ReportFuncTimingCtx, so logs can be joined with traces.
+func Logger(ctx context.Context) log.Logger {
+	return log.WithContext(ctx).WithFields(logFields(ctx))
+}
+
+func logFields(ctx context.Context) log.Fields {
+	tags := TagsFromContext(ctx)
+	fields := make(log.Fields, len(tags)+2)
+	for k, v := range tags {
+		fields[k] = v
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields["trace_id"] = sc.TraceID().String()
+		fields["span_id"] = sc.SpanID().String()
+	}
+	return fields
+}
+
+// logTraceIDs mirrors StatterConfig.LogTraceIDs, so loggers can be picked without holding clientMux.
+var logTraceIDs atomic.Bool
+
+// contextLogger is used by the package's own warnings and errors, it carries the trace ids when LogTraceIDs is enabled.
+func contextLogger(ctx context.Context) log.Logger {
+	if logTraceIDs.Load() {
+		return Logger(ctx)
+	}
+	return log.DefaultLogger
+}
@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestLoggerFields(t *testing.T) {
+	record(t)
+	restore := SetTracer(sdktrace.NewTracerProvider().Tracer("test"))
+	t.Cleanup(restore)
+
+	assert.Empty(t, logFields(context.Background()))
+
+	ctx, stopOuter := ReportFuncTimingCtx(context.Background(), Tags{"market": "INJ/USDT"})
+	ctx, stopInner := TimingCtx(ctx, "db.query", "table", "orders")
+	defer stopOuter()
+	defer stopInner()
+
+	sc := trace.SpanContextFromContext(ctx)
+	fields := logFields(ctx)
+	assert.Equal(t, sc.TraceID().String(), fields["trace_id"])
+	assert.Equal(t, sc.SpanID().String(), fields["span_id"])
+	assert.Equal(t, "INJ/USDT", fields["market"])
+	assert.Equal(t, "orders", fields["table"])
+	assert.Equal(t, "TestLoggerFields", fields["func_name"])
+	assert.NotNil(t, Logger(ctx))
+}
@@ -1,20 +1,18 @@
 package metrics
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"reflect"
 	"runtime"
+	"runtime/pprof"
 	"strconv"
 	"strings"
-	"time"
 
-	log "github.com/InjectiveLabs/suplog"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	"github.com/mixpanel/mixpanel-go"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/codes"
-	"go.opentelemetry.io/otel/trace"
 )
 
 func ReportFunc(fn, action string, tags ...Tags) {
@@ -63,7 +61,8 @@ func ReportFuncCallAndTiming(tags ...Tags) StopTimerFunc {
 func ReportFuncCallAndTimingCtx(ctx context.Context, tags ...Tags) (context.Context, StopTimerFunc) {
 	fn := CallerFuncName(1)
 	reportFunc(fn, "called", tags...)
-	return reportTiming(ctx, fn, tags...)
+	spanCtx, stopFn := reportTiming(ctx, fn, tags...)
+	return withPprofLabels(spanCtx, fn, stopFn, tags...)
 }
 
 func ReportFuncCallAndTimingSdkCtx(sdkCtx sdk.Context, tags ...Tags) (sdk.Context, StopTimerFunc) {
@@ -79,10 +78,10 @@ func ReportFuncCallAndTimingCtxWithErr(ctx context.Context, tags ...Tags) func(e
 
 func ReportNamedFuncCallAndTimingCtxWithErr(ctx context.Context, fn string, tags ...Tags) func(err *error, stopTags ...Tags) {
 	reportFunc(fn, "called", tags...)
-	_, stop := reportTiming(ctx, fn, tags...)
+	_, stop := startFuncTimer(ctx, fn, tags...)
 	return func(err *error, stopTags ...Tags) {
 		finalTags := MergeTags(MergeTags(nil, tags...), stopTags...)
-		stop(finalTags)
+		stop(derefErr(err), finalTags)
 		if err != nil && *err != nil {
 			ReportClosureFuncError(fn, finalTags)
 		}
@@ -96,9 +95,9 @@ func ReportFuncCallAndTimingWithErr(tags ...Tags) func(err *error, tags ...Tags)
 
 func ReportNamedFuncCallAndTimingWithErr(fn string, tags ...Tags) func(err *error, tags ...Tags) {
 	reportFunc(fn, "called", tags...)
-	_, stop := reportTiming(context.Background(), fn, tags...)
+	_, stop := startFuncTimer(context.Background(), fn, tags...)
 	return func(err *error, stopTags ...Tags) {
-		stop(stopTags...)
+		stop(derefErr(err), stopTags...)
 		if err != nil && *err != nil {
 			finalTags := MergeTags(MergeTags(nil, tags...), stopTags...)
 			ReportClosureFuncError(fn, finalTags)
@@ -119,7 +118,7 @@ func reportFunc(fn, action string, tags ...Tags) {
 
 	tagArray := JoinTags(tags...)
 	tagArray = append(tagArray, getSingleTag("func_name", fn))
-	client.Incr(fmt.Sprintf("func.%v", action), tagArray, 0.77)
+	client.Incr(fmt.Sprintf("func.%v", action), tagArray, 1)
 }
 
 type StopTimerFunc func(tags ...Tags)
@@ -130,111 +129,85 @@ func ReportFuncTiming(tags ...Tags) StopTimerFunc {
 }
 
 func ReportFuncTimingCtx(ctx context.Context, tags ...Tags) (context.Context, StopTimerFunc) {
-	return reportTiming(ctx, CallerFuncName(1), tags...)
+	fn := CallerFuncName(1)
+	spanCtx, stopFn := reportTiming(ctx, fn, tags...)
+	return withPprofLabels(spanCtx, fn, stopFn, tags...)
 }
 
-func reportTiming(ctx context.Context, fn string, tags ...Tags) (context.Context, StopTimerFunc) {
-	clientMux.RLock()
-	defer clientMux.RUnlock()
-
-	if client == nil {
-		return ctx, func(...Tags) {}
+// withPprofLabels runs the calling goroutine under pprof labels with func_name and selected tags until stopFn is called,
+// so CPU profiles can be broken down by the same names as func.timing. Like pprof.Do, stopping restores the labels
+// of ctx, so nested calls have to pass down the returned context, and it must run on the goroutine that started
+// the timer, otherwise the labels are left as they are rather than set on another goroutine.
+func withPprofLabels(ctx context.Context, fn string, stopFn StopTimerFunc, tags ...Tags) (context.Context, StopTimerFunc) {
+	if config == nil || !config.PprofLabelsEnabled {
+		return ctx, stopFn
 	}
-	t := time.Now()
 
-	if fn == "" {
-		fn = CallerFuncName(2)
+	labels := []string{"func_name", fn}
+	merged := MergeTags(nil, tags...)
+	for _, key := range config.PprofLabelTags {
+		if v, ok := merged[key]; ok && key != "func_name" {
+			labels = append(labels, key, v)
+		}
 	}
 
-	var (
-		span    trace.Span
-		spanCtx = ctx
-	)
-	if tracer != nil {
-		spanCtx, span = tracer.Start(ctx, fn)
-		for _, tags := range tags {
-			for k, v := range tags {
-				span.SetAttributes(attribute.String(k, v))
-			}
+	labeledCtx := pprof.WithLabels(ctx, pprof.Labels(labels...))
+	pprof.SetGoroutineLabels(labeledCtx)
+	gid := goroutineID()
+	return labeledCtx, func(stopTags ...Tags) {
+		stopFn(stopTags...)
+		if goroutineID() == gid {
+			pprof.SetGoroutineLabels(ctx)
 		}
 	}
+}
 
-	tagArray := JoinTags(tags...)
-	tagArray = append(tagArray, getSingleTag("func_name", fn))
-
-	doneC := make(chan struct{})
-	go func(name string, start time.Time) {
-		timeout := time.NewTimer(config.StuckFunctionTimeout)
-		defer timeout.Stop()
-
-		select {
-		case <-doneC:
-			return
-		case <-timeout.C:
-			clientMux.RLock()
-			defer clientMux.RUnlock()
-
-			err := fmt.Errorf("detected stuck function: %s stuck for %v", name, time.Since(start))
-			fmt.Println(err)
-			client.Incr("func.stuck", tagArray, 1)
-			if span != nil {
-				span.SetStatus(codes.Error, "stuck")
-				span.End()
-			}
-		}
-	}(fn, t)
+// goroutineID parses the id of the calling goroutine from its stack header, "goroutine 42 [running]:".
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if idx := bytes.IndexByte(b, ' '); idx > 0 {
+		b = b[:idx]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
 
+func reportTiming(ctx context.Context, fn string, tags ...Tags) (context.Context, StopTimerFunc) {
+	if fn == "" {
+		fn = CallerFuncName(2)
+	}
+	spanCtx, stop := startFuncTimer(ctx, fn, tags...)
 	return spanCtx, func(stopTags ...Tags) {
-		d := time.Since(t)
-		close(doneC)
-
-		stopTagArray := append(tagArray, JoinTags(stopTags...)...)
-
-		clientMux.RLock()
-		defer clientMux.RUnlock()
-		client.Timing("func.timing", d, stopTagArray, 1)
-		if span != nil {
-			span.End()
-		}
+		stop(nil, stopTags...)
 	}
 }
 
-func ReportClosureFuncTiming(name string, tags ...Tags) StopTimerFunc {
-	clientMux.RLock()
-	defer clientMux.RUnlock()
-	if client == nil {
-		return func(...Tags) {}
+// tagAttribute keeps integer, float and bool tag values typed on spans, as long as they convert back to the same string,
+// so e.g. "007" or "1e3" stay strings.
+func tagAttribute(k, v string) attribute.KeyValue {
+	if v == "true" || v == "false" {
+		return attribute.Bool(k, v == "true")
 	}
-	t := time.Now()
-	tagArray := JoinTags(tags...)
-	tagArray = append(tagArray, getSingleTag("func_name", name))
-
-	doneC := make(chan struct{})
-	go func(name string, start time.Time) {
-		timeout := time.NewTimer(config.StuckFunctionTimeout)
-		defer timeout.Stop()
-
-		select {
-		case <-doneC:
-			return
-		case <-timeout.C:
-			clientMux.RLock()
-			defer clientMux.RUnlock()
-
-			log.Warningf("detected stuck function: %s stuck for %v", name, time.Since(start))
-			client.Incr("func.stuck", tagArray, 1)
-		}
-	}(name, t)
-
-	return func(stopTags ...Tags) {
-		d := time.Since(t)
-		close(doneC)
-		stopTagArray := append(tagArray, JoinTags(stopTags...)...)
+	if i, err := strconv.ParseInt(v, 10, 64); err == nil && strconv.FormatInt(i, 10) == v {
+		return attribute.Int64(k, i)
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil && strconv.FormatFloat(f, 'f', -1, 64) == v {
+		return attribute.Float64(k, f)
+	}
+	return attribute.String(k, v)
+}
 
-		clientMux.RLock()
-		defer clientMux.RUnlock()
-		client.Timing("func.timing", d, stopTagArray, 1)
+func derefErr(err *error) error {
+	if err == nil {
+		return nil
 	}
+	return *err
+}
+
+func ReportClosureFuncTiming(name string, tags ...Tags) StopTimerFunc {
+	_, stopFn := reportTiming(context.Background(), name, tags...)
+	return stopFn
 }
 
 func CallerFuncName(skip int) string {
@@ -0,0 +1,50 @@
+package metricstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/InjectiveLabs/metrics"
+)
+
+// AssertCalled asserts that the metric name was reported at least once, optionally with all the tags.
+func (r *Recorder) AssertCalled(t testing.TB, name string, tags ...metrics.Tags) bool {
+	t.Helper()
+	calls := r.Named(name).WithTags(metrics.MergeTags(nil, tags...))
+	return assert.NotEmpty(t, calls, "expected metric %q to be reported with tags %v, got %v", name, tags, r.Calls())
+}
+
+// AssertNotCalled asserts that the metric name was never reported.
+func (r *Recorder) AssertNotCalled(t testing.TB, name string) bool {
+	t.Helper()
+	return assert.Empty(t, r.Named(name), "expected metric %q not to be reported", name)
+}
+
+// AssertCount asserts the total of Count, Incr and Decr calls for the metric name, optionally narrowed by tags.
+func (r *Recorder) AssertCount(t testing.TB, name string, expected int64, tags ...metrics.Tags) bool {
+	t.Helper()
+	sum := r.Named(name).WithTags(metrics.MergeTags(nil, tags...)).SumCounts()
+	return assert.Equal(t, expected, sum, "unexpected total for metric %q", name)
+}
+
+// AssertGauge asserts the last value recorded for the gauge name.
+func (r *Recorder) AssertGauge(t testing.TB, name string, expected float64) bool {
+	t.Helper()
+	v, ok := r.LastGauge(name)
+	if !assert.True(t, ok, "expected gauge %q to be reported", name) {
+		return false
+	}
+	return assert.Equal(t, expected, v, "unexpected value for gauge %q", name)
+}
+
+// AssertTimingAtLeast asserts that the metric name was timed at least once with a duration of min or more.
+func (r *Recorder) AssertTimingAtLeast(t testing.TB, name string, min time.Duration) bool {
+	t.Helper()
+	timings := r.Named(name).Method(MethodTiming)
+	if !assert.NotEmpty(t, timings, "expected metric %q to be timed", name) {
+		return false
+	}
+	return assert.GreaterOrEqual(t, timings.TimingPercentile(100), min, "metric %q timed shorter than expected", name)
+}
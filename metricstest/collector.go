@@ -0,0 +1,428 @@
+package metricstest
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	collectorlogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	MetricKindSum       = "sum"
+	MetricKindGauge     = "gauge"
+	MetricKindHistogram = "histogram"
+)
+
+// CollectedMetric is a single metric decoded from an OTLP export request.
+type CollectedMetric struct {
+	Name      string
+	Kind      string // MetricKindSum, MetricKindGauge or MetricKindHistogram
+	Unit      string
+	Monotonic bool // only meaningful for sums
+	Resource  map[string]string
+	Points    []CollectedPoint
+}
+
+// CollectedPoint is a single data point of a CollectedMetric.
+type CollectedPoint struct {
+	Attributes map[string]string
+	Value      float64 // sum or gauge value, histogram sum
+	Count      uint64  // histogram count
+}
+
+// CollectedSpan is a single span decoded from an OTLP export request.
+type CollectedSpan struct {
+	Name         string
+	TraceID      []byte
+	SpanID       []byte
+	ParentSpanID []byte
+	Attributes   map[string]string
+	Resource     map[string]string
+}
+
+// CollectedLog is a single log record decoded from an OTLP export request.
+type CollectedLog struct {
+	Body         string
+	SeverityText string
+	Severity     int32 // OTLP severity number, e.g. 13 for WARN
+	TraceID      []byte
+	SpanID       []byte
+	Attributes   map[string]string
+	Resource     map[string]string
+}
+
+// OTLPCollector is an in-process OTLP collector accepting metric, trace and log exports
+// over gRPC (GRPCAddr) and HTTP (HTTPURL + /v1/metrics, /v1/traces, /v1/logs) on loopback.
+type OTLPCollector struct {
+	GRPCAddr string // host:port to use as the OTEL agent address
+	HTTPURL  string // base URL of the OTLP/HTTP endpoint
+
+	mux     sync.Mutex
+	metrics []*collectormetrics.ExportMetricsServiceRequest
+	traces  []*collectortrace.ExportTraceServiceRequest
+	logs    []*collectorlogs.ExportLogsServiceRequest
+	headers []http.Header
+}
+
+// NewOTLPCollector starts a collector that is stopped at the end of the test.
+func NewOTLPCollector(t testing.TB) *OTLPCollector {
+	t.Helper()
+	c := &OTLPCollector{}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	collectormetrics.RegisterMetricsServiceServer(srv, &metricsService{c: c})
+	collectortrace.RegisterTraceServiceServer(srv, &traceService{c: c})
+	collectorlogs.RegisterLogsServiceServer(srv, &logsService{c: c})
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	c.GRPCAddr = lis.Addr().String()
+
+	httpSrv := httptest.NewServer(http.HandlerFunc(c.serveHTTP))
+	c.HTTPURL = httpSrv.URL
+
+	t.Cleanup(func() {
+		srv.Stop()
+		httpSrv.Close()
+	})
+	return c
+}
+
+// Headers returns request headers (gRPC metadata or HTTP headers) of every export request received so far.
+func (c *OTLPCollector) Headers() []http.Header {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return append([]http.Header(nil), c.headers...)
+}
+
+// MetricRequests returns raw metric export requests received so far.
+func (c *OTLPCollector) MetricRequests() []*collectormetrics.ExportMetricsServiceRequest {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return append([]*collectormetrics.ExportMetricsServiceRequest(nil), c.metrics...)
+}
+
+// TraceRequests returns raw trace export requests received so far.
+func (c *OTLPCollector) TraceRequests() []*collectortrace.ExportTraceServiceRequest {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return append([]*collectortrace.ExportTraceServiceRequest(nil), c.traces...)
+}
+
+// LogRequests returns raw log export requests received so far.
+func (c *OTLPCollector) LogRequests() []*collectorlogs.ExportLogsServiceRequest {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return append([]*collectorlogs.ExportLogsServiceRequest(nil), c.logs...)
+}
+
+// Metrics returns all metrics decoded from the export requests received so far.
+func (c *OTLPCollector) Metrics() []CollectedMetric {
+	var res []CollectedMetric
+	for _, req := range c.MetricRequests() {
+		for _, rm := range req.GetResourceMetrics() {
+			resource := resourceAttrs(rm.GetResource())
+			for _, sm := range rm.GetScopeMetrics() {
+				for _, m := range sm.GetMetrics() {
+					res = append(res, decodeMetric(m, resource))
+				}
+			}
+		}
+	}
+	return res
+}
+
+// Metric returns the last collected metric with the name.
+func (c *OTLPCollector) Metric(name string) (CollectedMetric, bool) {
+	metrics := c.Metrics()
+	for i := len(metrics) - 1; i >= 0; i-- {
+		if metrics[i].Name == name {
+			return metrics[i], true
+		}
+	}
+	return CollectedMetric{}, false
+}
+
+// Spans returns all spans decoded from the export requests received so far.
+func (c *OTLPCollector) Spans() []CollectedSpan {
+	var res []CollectedSpan
+	for _, req := range c.TraceRequests() {
+		for _, rs := range req.GetResourceSpans() {
+			resource := resourceAttrs(rs.GetResource())
+			for _, ss := range rs.GetScopeSpans() {
+				for _, s := range ss.GetSpans() {
+					res = append(res, CollectedSpan{
+						Name:         s.GetName(),
+						TraceID:      s.GetTraceId(),
+						SpanID:       s.GetSpanId(),
+						ParentSpanID: s.GetParentSpanId(),
+						Attributes:   keyValues(s.GetAttributes()),
+						Resource:     resource,
+					})
+				}
+			}
+		}
+	}
+	return res
+}
+
+// Logs returns all log records decoded from the export requests received so far.
+func (c *OTLPCollector) Logs() []CollectedLog {
+	var res []CollectedLog
+	for _, req := range c.LogRequests() {
+		for _, rl := range req.GetResourceLogs() {
+			resource := resourceAttrs(rl.GetResource())
+			for _, sl := range rl.GetScopeLogs() {
+				for _, l := range sl.GetLogRecords() {
+					res = append(res, CollectedLog{
+						Body:         anyValueString(l.GetBody()),
+						SeverityText: l.GetSeverityText(),
+						Severity:     int32(l.GetSeverityNumber()),
+						TraceID:      l.GetTraceId(),
+						SpanID:       l.GetSpanId(),
+						Attributes:   keyValues(l.GetAttributes()),
+						Resource:     resource,
+					})
+				}
+			}
+		}
+	}
+	return res
+}
+
+// WaitForLog waits until a log record with the body is collected.
+func (c *OTLPCollector) WaitForLog(t testing.TB, body string, timeout time.Duration) CollectedLog {
+	t.Helper()
+	find := func() (CollectedLog, bool) {
+		for _, l := range c.Logs() {
+			if l.Body == body {
+				return l, true
+			}
+		}
+		return CollectedLog{}, false
+	}
+	require.Eventually(t, func() bool {
+		_, ok := find()
+		return ok
+	}, timeout, 10*time.Millisecond, "log %q was not collected", body)
+	l, _ := find()
+	return l
+}
+
+// WaitForMetric waits until a metric with the name is collected.
+func (c *OTLPCollector) WaitForMetric(t testing.TB, name string, timeout time.Duration) CollectedMetric {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		_, ok := c.Metric(name)
+		return ok
+	}, timeout, 10*time.Millisecond, "metric %q was not collected", name)
+	m, _ := c.Metric(name)
+	return m
+}
+
+func (c *OTLPCollector) addMetrics(req *collectormetrics.ExportMetricsServiceRequest, h http.Header) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.metrics = append(c.metrics, req)
+	c.headers = append(c.headers, h)
+}
+
+func (c *OTLPCollector) addTraces(req *collectortrace.ExportTraceServiceRequest, h http.Header) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.traces = append(c.traces, req)
+	c.headers = append(c.headers, h)
+}
+
+func (c *OTLPCollector) addLogs(req *collectorlogs.ExportLogsServiceRequest, h http.Header) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.logs = append(c.logs, req)
+	c.headers = append(c.headers, h)
+}
+
+func (c *OTLPCollector) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resp proto.Message
+	switch r.URL.Path {
+	case "/v1/metrics":
+		req := &collectormetrics.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(data, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.addMetrics(req, r.Header.Clone())
+		resp = &collectormetrics.ExportMetricsServiceResponse{}
+	case "/v1/traces":
+		req := &collectortrace.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(data, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.addTraces(req, r.Header.Clone())
+		resp = &collectortrace.ExportTraceServiceResponse{}
+	case "/v1/logs":
+		req := &collectorlogs.ExportLogsServiceRequest{}
+		if err := proto.Unmarshal(data, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.addLogs(req, r.Header.Clone())
+		resp = &collectorlogs.ExportLogsServiceResponse{}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	out, err := proto.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(out)
+}
+
+type metricsService struct {
+	collectormetrics.UnimplementedMetricsServiceServer
+	c *OTLPCollector
+}
+
+func (s *metricsService) Export(ctx context.Context, req *collectormetrics.ExportMetricsServiceRequest) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	s.c.addMetrics(req, metadataHeader(ctx))
+	return &collectormetrics.ExportMetricsServiceResponse{}, nil
+}
+
+type traceService struct {
+	collectortrace.UnimplementedTraceServiceServer
+	c *OTLPCollector
+}
+
+func (s *traceService) Export(ctx context.Context, req *collectortrace.ExportTraceServiceRequest) (*collectortrace.ExportTraceServiceResponse, error) {
+	s.c.addTraces(req, metadataHeader(ctx))
+	return &collectortrace.ExportTraceServiceResponse{}, nil
+}
+
+type logsService struct {
+	collectorlogs.UnimplementedLogsServiceServer
+	c *OTLPCollector
+}
+
+func (s *logsService) Export(ctx context.Context, req *collectorlogs.ExportLogsServiceRequest) (*collectorlogs.ExportLogsServiceResponse, error) {
+	s.c.addLogs(req, metadataHeader(ctx))
+	return &collectorlogs.ExportLogsServiceResponse{}, nil
+}
+
+func metadataHeader(ctx context.Context) http.Header {
+	h := make(http.Header)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, values := range md {
+		for _, v := range values {
+			h.Add(k, v)
+		}
+	}
+	return h
+}
+
+func decodeMetric(m *metricpb.Metric, resource map[string]string) CollectedMetric {
+	res := CollectedMetric{
+		Name:     m.GetName(),
+		Unit:     m.GetUnit(),
+		Resource: resource,
+	}
+	switch data := m.GetData().(type) {
+	case *metricpb.Metric_Sum:
+		res.Kind = MetricKindSum
+		res.Monotonic = data.Sum.GetIsMonotonic()
+		for _, dp := range data.Sum.GetDataPoints() {
+			res.Points = append(res.Points, numberPoint(dp))
+		}
+	case *metricpb.Metric_Gauge:
+		res.Kind = MetricKindGauge
+		for _, dp := range data.Gauge.GetDataPoints() {
+			res.Points = append(res.Points, numberPoint(dp))
+		}
+	case *metricpb.Metric_Histogram:
+		res.Kind = MetricKindHistogram
+		for _, dp := range data.Histogram.GetDataPoints() {
+			res.Points = append(res.Points, CollectedPoint{
+				Attributes: keyValues(dp.GetAttributes()),
+				Value:      dp.GetSum(),
+				Count:      dp.GetCount(),
+			})
+		}
+	}
+	return res
+}
+
+func numberPoint(dp *metricpb.NumberDataPoint) CollectedPoint {
+	p := CollectedPoint{Attributes: keyValues(dp.GetAttributes())}
+	switch v := dp.GetValue().(type) {
+	case *metricpb.NumberDataPoint_AsInt:
+		p.Value = float64(v.AsInt)
+	case *metricpb.NumberDataPoint_AsDouble:
+		p.Value = v.AsDouble
+	}
+	return p
+}
+
+func resourceAttrs(r *resourcepb.Resource) map[string]string {
+	return keyValues(r.GetAttributes())
+}
+
+func keyValues(kvs []*commonpb.KeyValue) map[string]string {
+	res := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		res[kv.GetKey()] = anyValueString(kv.GetValue())
+	}
+	return res
+}
+
+func anyValueString(v *commonpb.AnyValue) string {
+	switch x := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return x.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(x.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(x.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(x.DoubleValue, 'f', -1, 64)
+	default:
+		return v.String()
+	}
+}
@@ -0,0 +1,188 @@
+package metricstest_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/InjectiveLabs/metrics"
+	"github.com/InjectiveLabs/metrics/metricstest"
+)
+
+func tracedFunc(ctx context.Context) {
+	_, stop := metrics.ReportFuncCallAndTimingCtx(ctx, metrics.Tags{"market": "INJ/USDT"})
+	defer stop()
+}
+
+func TestOTLPCollectorWithOTELAgent(t *testing.T) {
+	collector := metricstest.NewOTLPCollector(t)
+
+	err := metrics.Init(collector.GRPCAddr, "svc.", &metrics.StatterConfig{
+		Agent:          metrics.OTELAgent,
+		EnvName:        "test",
+		HostName:       "host1",
+		DefaultTags:    []interface{}{"chain", "injective-888"},
+		TracingEnabled: true,
+		OTELInsecure:   true,
+		OTELHeaders:    map[string]string{"signoz-access-token": "secret"},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		metrics.SetStatter(nil)
+		metrics.SetTracer(nil)
+	})
+
+	metrics.Counter("orders", 3, "market", "INJ/USDT")
+	metrics.Gauge("queue.size", 7)
+	metrics.Timer("db.query", 15*time.Millisecond, metrics.Tags{"table": "orders"})
+	tracedFunc(context.Background())
+
+	// shutting down providers flushes pending exports
+	metrics.Close()
+
+	orders := collector.WaitForMetric(t, "svc.orders", 5*time.Second)
+	assert.Equal(t, metricstest.MetricKindSum, orders.Kind)
+	assert.False(t, orders.Monotonic)
+	require.Len(t, orders.Points, 1)
+	assert.EqualValues(t, 3, orders.Points[0].Value)
+	assert.Equal(t, "INJ/USDT", orders.Points[0].Attributes["market"])
+	assert.Equal(t, map[string]string{"env": "test", "machine": "host1", "chain": "injective-888"}, orders.Resource)
+
+	gauge, ok := collector.Metric("svc.queue.size")
+	require.True(t, ok)
+	assert.Equal(t, metricstest.MetricKindGauge, gauge.Kind)
+	assert.EqualValues(t, 7, gauge.Points[0].Value)
+
+	timing, ok := collector.Metric("svc.db.query")
+	require.True(t, ok)
+	assert.Equal(t, metricstest.MetricKindHistogram, timing.Kind)
+	assert.Equal(t, "ms", timing.Unit)
+	assert.EqualValues(t, 1, timing.Points[0].Count)
+	assert.InDelta(t, 15, timing.Points[0].Value, 0.001)
+
+	require.Eventually(t, func() bool { return len(collector.Spans()) > 0 }, 5*time.Second, 10*time.Millisecond)
+	span := collector.Spans()[0]
+	assert.Equal(t, "tracedFunc", span.Name)
+	assert.Equal(t, "INJ/USDT", span.Attributes["market"])
+	assert.Equal(t, "test", span.Resource["env"])
+
+	for _, h := range collector.Headers() {
+		assert.Equal(t, "secret", h.Get("signoz-access-token"))
+	}
+}
+
+func loggingFunc(ctx context.Context) {
+	ctx, stop := metrics.ReportFuncTimingCtx(ctx, metrics.Tags{"market": "INJ/USDT"})
+	defer stop()
+	metrics.Logger(ctx).WithField("attempt", 2).Warningln("order rejected")
+}
+
+func TestOTLPCollectorLogs(t *testing.T) {
+	collector := metricstest.NewOTLPCollector(t)
+
+	err := metrics.Init(collector.GRPCAddr, "svc.", &metrics.StatterConfig{
+		Agent:           metrics.OTELAgent,
+		EnvName:         "test",
+		TracingEnabled:  true,
+		OTELInsecure:    true,
+		OTELLogsEnabled: true,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		metrics.SetStatter(nil)
+		metrics.SetTracer(nil)
+	})
+
+	loggingFunc(context.Background())
+	metrics.Close()
+
+	record := collector.WaitForLog(t, "order rejected", 5*time.Second)
+	assert.Equal(t, "warning", record.SeverityText)
+	assert.EqualValues(t, 13, record.Severity)
+	assert.Equal(t, "INJ/USDT", record.Attributes["market"])
+	assert.Equal(t, "2", record.Attributes["attempt"])
+	assert.Equal(t, "test", record.Resource["env"])
+
+	require.Eventually(t, func() bool { return len(collector.Spans()) > 0 }, 5*time.Second, 10*time.Millisecond)
+	span := collector.Spans()[0]
+	assert.Equal(t, span.TraceID, record.TraceID)
+	assert.Equal(t, span.SpanID, record.SpanID)
+}
+
+func TestOTLPTracesWithTelegrafMetrics(t *testing.T) {
+	collector := metricstest.NewOTLPCollector(t)
+	listener := metricstest.NewUDPListener(t)
+
+	err := metrics.Init(listener.Addr, "svc.", &metrics.StatterConfig{
+		Agent:          metrics.TelegrafAgent,
+		EnvName:        "test",
+		TracingEnabled: true,
+		TraceExporter:  metrics.TraceExporterOTLP,
+		TraceEndpoint:  collector.GRPCAddr,
+		TraceInsecure:  true,
+		TraceHeaders:   map[string]string{"signoz-access-token": "secret"},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		metrics.SetStatter(nil)
+		metrics.SetTracer(nil)
+	})
+
+	tracedFunc(context.Background())
+	metrics.Close()
+
+	lines := listener.WaitForLines(t, 1, 5*time.Second)
+	assert.Contains(t, strings.Join(lines, "\n"), "func.timing,")
+
+	require.Eventually(t, func() bool { return len(collector.Spans()) > 0 }, 5*time.Second, 10*time.Millisecond)
+	span := collector.Spans()[0]
+	assert.Equal(t, "tracedFunc", span.Name)
+	assert.Equal(t, "test", span.Resource["env"])
+	assert.Equal(t, "secret", collector.Headers()[0].Get("signoz-access-token"))
+	assert.Empty(t, collector.MetricRequests())
+}
+
+func TestOTLPCollectorHTTP(t *testing.T) {
+	collector := metricstest.NewOTLPCollector(t)
+
+	req := &collectormetrics.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricpb.ResourceMetrics{{
+			ScopeMetrics: []*metricpb.ScopeMetrics{{
+				Metrics: []*metricpb.Metric{{
+					Name: "http.metric",
+					Data: &metricpb.Metric_Gauge{Gauge: &metricpb.Gauge{
+						DataPoints: []*metricpb.NumberDataPoint{{
+							Value: &metricpb.NumberDataPoint_AsDouble{AsDouble: 1.5},
+						}},
+					}},
+				}},
+			}},
+		}},
+	}
+	body, err := proto.Marshal(req)
+	require.NoError(t, err)
+
+	httpReq, err := http.NewRequest(http.MethodPost, collector.HTTPURL+"/v1/metrics", bytes.NewReader(body))
+	require.NoError(t, err)
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("signoz-access-token", "secret")
+	resp, err := http.DefaultClient.Do(httpReq)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	m, ok := collector.Metric("http.metric")
+	require.True(t, ok)
+	assert.Equal(t, metricstest.MetricKindGauge, m.Kind)
+	assert.Equal(t, 1.5, m.Points[0].Value)
+	assert.Equal(t, "secret", collector.Headers()[0].Get("signoz-access-token"))
+}
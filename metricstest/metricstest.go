@@ -0,0 +1,268 @@
+// Package metricstest provides an in-memory metrics.Statter with query and assertion helpers,
+// so tests don't need to swap the package-level statter by hand.
+//
+//	rec := metricstest.New(t)
+//	doSomething()
+//	rec.AssertCount(t, "func.called", 1)
+//
+// Recorders are installed for the lifetime of the test and removed on cleanup. Parallel tests
+// share the package-level statter, so every active recorder sees calls from all of them;
+// filter by metric name or tags that are unique to the test.
+package metricstest
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/InjectiveLabs/metrics"
+)
+
+const (
+	MethodCount     = "Count"
+	MethodIncr      = "Incr"
+	MethodDecr      = "Decr"
+	MethodGauge     = "Gauge"
+	MethodTiming    = "Timing"
+	MethodHistogram = "Histogram"
+)
+
+// Call is a single recorded Statter call.
+type Call struct {
+	Method   string
+	Name     string
+	Value    float64       // count delta, gauge or histogram value
+	Duration time.Duration // timing value
+	Tags     map[string]string
+	Rate     float64
+}
+
+// Calls is a list of recorded calls that can be narrowed down with chained queries.
+type Calls []Call
+
+// Recorder is an in-memory metrics.Statter scoped to a single test.
+type Recorder struct {
+	mux   sync.Mutex
+	calls Calls
+}
+
+var (
+	// installMux guards installing and restoring the package-level statter,
+	// recordersMux guards the set of active recorders used on every call.
+	installMux   sync.Mutex
+	restore      func()
+	recordersMux sync.RWMutex
+	recorders    = make(map[*Recorder]struct{})
+)
+
+// New installs a recorder as the package-level statter until the end of the test.
+func New(t testing.TB) *Recorder {
+	t.Helper()
+	rec := &Recorder{}
+
+	installMux.Lock()
+	if restore == nil {
+		restore = metrics.SetStatter(fanout{})
+	}
+	recordersMux.Lock()
+	recorders[rec] = struct{}{}
+	recordersMux.Unlock()
+	installMux.Unlock()
+
+	t.Cleanup(func() {
+		installMux.Lock()
+		defer installMux.Unlock()
+
+		recordersMux.Lock()
+		delete(recorders, rec)
+		last := len(recorders) == 0
+		recordersMux.Unlock()
+
+		if last && restore != nil {
+			restore()
+			restore = nil
+		}
+	})
+	return rec
+}
+
+// Reset drops all recorded calls.
+func (r *Recorder) Reset() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.calls = nil
+}
+
+// Calls returns a copy of all recorded calls.
+func (r *Recorder) Calls() Calls {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append(Calls(nil), r.calls...)
+}
+
+// Named returns the calls for the metric name.
+func (r *Recorder) Named(name string) Calls {
+	return r.Calls().Named(name)
+}
+
+// SumCounts returns the total of Count, Incr and Decr calls for the metric name.
+func (r *Recorder) SumCounts(name string) int64 {
+	return r.Calls().Named(name).SumCounts()
+}
+
+// TimingPercentile returns the p-th (0-100) percentile of Timing calls for the metric name.
+func (r *Recorder) TimingPercentile(name string, p float64) time.Duration {
+	return r.Calls().Named(name).TimingPercentile(p)
+}
+
+// LastGauge returns the last value recorded for the gauge name.
+func (r *Recorder) LastGauge(name string) (float64, bool) {
+	gauges := r.Calls().Named(name).Method(MethodGauge)
+	if len(gauges) == 0 {
+		return 0, false
+	}
+	return gauges[len(gauges)-1].Value, true
+}
+
+func (r *Recorder) record(c Call) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.calls = append(r.calls, c)
+}
+
+// Named returns the calls for the metric name.
+func (c Calls) Named(name string) Calls {
+	return c.filter(func(call Call) bool { return call.Name == name })
+}
+
+// Method returns the calls of a single Statter method, e.g. MethodTiming.
+func (c Calls) Method(method string) Calls {
+	return c.filter(func(call Call) bool { return call.Method == method })
+}
+
+// WithTag returns the calls carrying the tag key with the value.
+func (c Calls) WithTag(key, value string) Calls {
+	return c.filter(func(call Call) bool {
+		v, ok := call.Tags[key]
+		return ok && v == value
+	})
+}
+
+// WithTags returns the calls carrying all the tags.
+func (c Calls) WithTags(tags metrics.Tags) Calls {
+	res := c
+	for k, v := range tags {
+		res = res.WithTag(k, v)
+	}
+	return res
+}
+
+// SumCounts returns the total of Count, Incr and Decr calls.
+func (c Calls) SumCounts() int64 {
+	var sum int64
+	for _, call := range c {
+		switch call.Method {
+		case MethodCount, MethodIncr, MethodDecr:
+			sum += int64(call.Value)
+		}
+	}
+	return sum
+}
+
+// TimingPercentile returns the p-th (0-100) percentile of Timing calls using the nearest-rank method.
+func (c Calls) TimingPercentile(p float64) time.Duration {
+	timings := c.Method(MethodTiming)
+	if len(timings) == 0 {
+		return 0
+	}
+	durations := make([]time.Duration, 0, len(timings))
+	for _, call := range timings {
+		durations = append(durations, call.Duration)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	rank := int(math.Ceil(p / 100 * float64(len(durations))))
+	if rank < 1 {
+		rank = 1
+	} else if rank > len(durations) {
+		rank = len(durations)
+	}
+	return durations[rank-1]
+}
+
+func (c Calls) filter(keep func(Call) bool) Calls {
+	var res Calls
+	for _, call := range c {
+		if keep(call) {
+			res = append(res, call)
+		}
+	}
+	return res
+}
+
+// parseTags converts tags joined by the metrics package into a map, "key:value" for the Datadog agent
+// and "key=value" otherwise. Only the agent's separator is used, so values may contain the other one.
+func parseTags(tags []string) map[string]string {
+	sep := byte('=')
+	if joined := metrics.JoinTags(metrics.Tags{"k": "v"}); len(joined) == 1 && len(joined[0]) == 3 {
+		sep = joined[0][1]
+	}
+
+	res := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		if idx := strings.IndexByte(tag, sep); idx > 0 {
+			res[tag[:idx]] = tag[idx+1:]
+		} else {
+			res[tag] = ""
+		}
+	}
+	return res
+}
+
+// fanout is the statter installed into the metrics package, it hands every call to all active recorders.
+type fanout struct{}
+
+func (fanout) dispatch(c Call) {
+	recordersMux.RLock()
+	defer recordersMux.RUnlock()
+	for rec := range recorders {
+		rec.record(c)
+	}
+}
+
+func (f fanout) Count(name string, value int64, tags []string, rate float64) error {
+	f.dispatch(Call{Method: MethodCount, Name: name, Value: float64(value), Tags: parseTags(tags), Rate: rate})
+	return nil
+}
+
+func (f fanout) Incr(name string, tags []string, rate float64) error {
+	f.dispatch(Call{Method: MethodIncr, Name: name, Value: 1, Tags: parseTags(tags), Rate: rate})
+	return nil
+}
+
+func (f fanout) Decr(name string, tags []string, rate float64) error {
+	f.dispatch(Call{Method: MethodDecr, Name: name, Value: -1, Tags: parseTags(tags), Rate: rate})
+	return nil
+}
+
+func (f fanout) Gauge(name string, value float64, tags []string, rate float64) error {
+	f.dispatch(Call{Method: MethodGauge, Name: name, Value: value, Tags: parseTags(tags), Rate: rate})
+	return nil
+}
+
+func (f fanout) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	f.dispatch(Call{Method: MethodTiming, Name: name, Duration: value, Tags: parseTags(tags), Rate: rate})
+	return nil
+}
+
+func (f fanout) Histogram(name string, value float64, tags []string, rate float64) error {
+	f.dispatch(Call{Method: MethodHistogram, Name: name, Value: value, Tags: parseTags(tags), Rate: rate})
+	return nil
+}
+
+func (fanout) Close() error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package metricstest_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/InjectiveLabs/metrics"
+	"github.com/InjectiveLabs/metrics/metricstest"
+)
+
+func TestRecorder(t *testing.T) {
+	rec := metricstest.New(t)
+
+	metrics.Counter("orders", 5, "market", "INJ/USDT")
+	metrics.Incr("orders", "market", "ETH/USDT")
+	metrics.Gauge("queue.size", 3)
+	metrics.Gauge("queue.size", 7)
+	for i := 1; i <= 10; i++ {
+		metrics.Timer("db.query", time.Duration(i)*time.Millisecond, metrics.Tags{"table": "orders"})
+	}
+
+	rec.AssertCount(t, "orders", 6)
+	rec.AssertCount(t, "orders", 5, metrics.Tags{"market": "INJ/USDT"})
+	rec.AssertCalled(t, "orders", metrics.Tags{"market": "ETH/USDT"})
+	rec.AssertNotCalled(t, "trades")
+	rec.AssertGauge(t, "queue.size", 7)
+	rec.AssertTimingAtLeast(t, "db.query", 10*time.Millisecond)
+
+	assert.Equal(t, 5*time.Millisecond, rec.TimingPercentile("db.query", 50))
+	assert.Equal(t, 9*time.Millisecond, rec.TimingPercentile("db.query", 90))
+	assert.Len(t, rec.Named("db.query").WithTag("table", "orders"), 10)
+	assert.Empty(t, rec.Named("db.query").WithTag("table", "trades"))
+
+	rec.Reset()
+	assert.Empty(t, rec.Calls())
+}
+
+func TestRecorderFuncHelpers(t *testing.T) {
+	rec := metricstest.New(t)
+
+	func() (err error) {
+		defer metrics.ReportNamedFuncCallAndTimingWithErr("doWork", metrics.Tags{"foo": "bar"})(&err)
+		return fmt.Errorf("failed")
+	}()
+
+	rec.AssertCount(t, "func.called", 1, metrics.Tags{"func_name": "doWork", "foo": "bar"})
+	rec.AssertCount(t, "func.error", 1, metrics.Tags{"func_name": "doWork"})
+	rec.AssertCalled(t, "func.timing", metrics.Tags{"func_name": "doWork"})
+}
+
+func TestRecorderParallel(t *testing.T) {
+	for i := 0; i < 5; i++ {
+		market := fmt.Sprintf("market-%d", i)
+		t.Run(market, func(t *testing.T) {
+			t.Parallel()
+			rec := metricstest.New(t)
+
+			for j := 0; j < 100; j++ {
+				metrics.Incr("parallel.orders", "market", market)
+			}
+			// other tests' calls are visible too, so narrow down by the unique tag
+			rec.AssertCount(t, "parallel.orders", 100, metrics.Tags{"market": market})
+		})
+	}
+}
+
+func TestRecorderIsRemovedOnCleanup(t *testing.T) {
+	var rec *metricstest.Recorder
+	t.Run("install", func(t *testing.T) {
+		rec = metricstest.New(t)
+		metrics.Incr("installed")
+	})
+
+	metrics.Incr("installed")
+	assert.Equal(t, int64(1), rec.SumCounts("installed"))
+}
+
+func TestRecorderDatadogTags(t *testing.T) {
+	require.NoError(t, metrics.Init("", "svc.", &metrics.StatterConfig{Agent: metrics.DatadogAgent, MockingEnabled: true}))
+	t.Cleanup(func() {
+		_ = metrics.Init("", "", &metrics.StatterConfig{MockingEnabled: true})
+	})
+	rec := metricstest.New(t)
+
+	metrics.Incr("queries", "query", "a=b", "addr", "host:8080")
+	rec.AssertCount(t, "queries", 1, metrics.Tags{"query": "a=b", "addr": "host:8080"})
+}
@@ -0,0 +1,183 @@
+package metricstest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/InjectiveLabs/metrics"
+)
+
+// Span is a finished span recorded by SpanRecorder.
+type Span struct {
+	Name              string
+	TraceID           string
+	SpanID            string
+	ParentSpanID      string // empty for root spans
+	Attributes        map[string]interface{}
+	StatusCode        codes.Code
+	StatusDescription string
+	Events            []string // names of span events, e.g. "exception" for recorded errors
+	Start             time.Time
+	End               time.Time
+}
+
+// SpanRecorder collects spans created by the metrics package in memory.
+type SpanRecorder struct {
+	mux   sync.Mutex
+	spans []Span
+}
+
+var (
+	spanInstallMux    sync.Mutex
+	spanRestore       func()
+	spanRecordersMux  sync.RWMutex
+	spanRecorders     = make(map[*SpanRecorder]struct{})
+	spanTraceProvider *sdktrace.TracerProvider
+)
+
+// RecordSpans installs an in-memory span exporter as the tracer of the metrics package until the end of the test.
+// Spans are only created for timed functions while a statter is installed, e.g. with New.
+// Like Recorder, parallel tests see each other's spans.
+func RecordSpans(t testing.TB) *SpanRecorder {
+	t.Helper()
+	rec := &SpanRecorder{}
+
+	spanInstallMux.Lock()
+	if spanRestore == nil {
+		spanTraceProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanFanout{}))
+		spanRestore = metrics.SetTracer(spanTraceProvider.Tracer("metricstest"))
+	}
+	spanRecordersMux.Lock()
+	spanRecorders[rec] = struct{}{}
+	spanRecordersMux.Unlock()
+	spanInstallMux.Unlock()
+
+	t.Cleanup(func() {
+		spanInstallMux.Lock()
+		defer spanInstallMux.Unlock()
+
+		spanRecordersMux.Lock()
+		delete(spanRecorders, rec)
+		last := len(spanRecorders) == 0
+		spanRecordersMux.Unlock()
+
+		if last && spanRestore != nil {
+			spanRestore()
+			spanRestore = nil
+			_ = spanTraceProvider.Shutdown(context.Background())
+		}
+	})
+	return rec
+}
+
+// Spans returns all finished spans in the order they ended.
+func (r *SpanRecorder) Spans() []Span {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append([]Span(nil), r.spans...)
+}
+
+// Named returns finished spans with the name.
+func (r *SpanRecorder) Named(name string) []Span {
+	var res []Span
+	for _, s := range r.Spans() {
+		if s.Name == name {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// Children returns finished spans whose parent is the span.
+func (r *SpanRecorder) Children(parent Span) []Span {
+	var res []Span
+	for _, s := range r.Spans() {
+		if s.TraceID == parent.TraceID && s.ParentSpanID == parent.SpanID {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// Reset drops all recorded spans.
+func (r *SpanRecorder) Reset() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.spans = nil
+}
+
+// AssertChildOf asserts that a span named child was recorded as a direct child of a span named parent.
+func (r *SpanRecorder) AssertChildOf(t testing.TB, child, parent string) bool {
+	t.Helper()
+	for _, p := range r.Named(parent) {
+		for _, c := range r.Children(p) {
+			if c.Name == child {
+				return true
+			}
+		}
+	}
+	return assert.Fail(t, "span is not a child", "expected span %q to be a child of %q, got %v", child, parent, r.Spans())
+}
+
+// AssertStatus asserts the status code of the last finished span with the name.
+func (r *SpanRecorder) AssertStatus(t testing.TB, name string, code codes.Code) bool {
+	t.Helper()
+	spans := r.Named(name)
+	if !assert.NotEmpty(t, spans, "expected span %q to be recorded", name) {
+		return false
+	}
+	return assert.Equal(t, code, spans[len(spans)-1].StatusCode, "unexpected status of span %q", name)
+}
+
+func (r *SpanRecorder) record(s Span) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.spans = append(r.spans, s)
+}
+
+// spanFanout is the span processor of the installed tracer provider, it hands every finished span to all active recorders.
+type spanFanout struct{}
+
+func (spanFanout) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+func (spanFanout) OnEnd(s sdktrace.ReadOnlySpan) {
+	span := Span{
+		Name:              s.Name(),
+		TraceID:           s.SpanContext().TraceID().String(),
+		SpanID:            s.SpanContext().SpanID().String(),
+		Attributes:        make(map[string]interface{}, len(s.Attributes())),
+		StatusCode:        s.Status().Code,
+		StatusDescription: s.Status().Description,
+		Start:             s.StartTime(),
+		End:               s.EndTime(),
+	}
+	if s.Parent().IsValid() {
+		span.ParentSpanID = s.Parent().SpanID().String()
+	}
+	for _, attr := range s.Attributes() {
+		span.Attributes[string(attr.Key)] = attr.Value.AsInterface()
+	}
+	for _, ev := range s.Events() {
+		span.Events = append(span.Events, ev.Name)
+	}
+
+	spanRecordersMux.RLock()
+	defer spanRecordersMux.RUnlock()
+	for rec := range spanRecorders {
+		rec.record(span)
+	}
+}
+
+func (spanFanout) Shutdown(context.Context) error {
+	return nil
+}
+
+func (spanFanout) ForceFlush(context.Context) error {
+	return nil
+}
@@ -0,0 +1,47 @@
+package metricstest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/InjectiveLabs/metrics"
+	"github.com/InjectiveLabs/metrics/metricstest"
+)
+
+func outerFunc(ctx context.Context) {
+	ctx, stop := metrics.ReportFuncCallAndTimingCtx(ctx, metrics.Tags{"market": "INJ/USDT"})
+	defer stop()
+	innerFunc(ctx)
+}
+
+func innerFunc(ctx context.Context) {
+	_, stop := metrics.ReportFuncCallAndTimingCtx(ctx)
+	defer stop()
+}
+
+func TestRecordSpans(t *testing.T) {
+	metricstest.New(t)
+	spans := metricstest.RecordSpans(t)
+
+	outerFunc(context.Background())
+
+	require.Len(t, spans.Spans(), 2)
+	spans.AssertChildOf(t, "innerFunc", "outerFunc")
+	spans.AssertStatus(t, "outerFunc", codes.Unset)
+
+	outer := spans.Named("outerFunc")[0]
+	assert.Empty(t, outer.ParentSpanID)
+	assert.Equal(t, "INJ/USDT", outer.Attributes["market"])
+	assert.False(t, outer.End.Before(outer.Start))
+
+	inner := spans.Named("innerFunc")[0]
+	assert.Equal(t, outer.TraceID, inner.TraceID)
+	assert.Equal(t, outer.SpanID, inner.ParentSpanID)
+
+	spans.Reset()
+	assert.Empty(t, spans.Spans())
+}
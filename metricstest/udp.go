@@ -0,0 +1,87 @@
+package metricstest
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// UDPListener captures statsd datagrams sent to Addr on loopback.
+type UDPListener struct {
+	Addr string
+
+	conn    *net.UDPConn
+	mux     sync.Mutex
+	packets []string
+}
+
+// NewUDPListener starts a listener that is closed at the end of the test.
+func NewUDPListener(t testing.TB) *UDPListener {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	l := &UDPListener{
+		Addr: conn.LocalAddr().String(),
+		conn: conn,
+	}
+	go l.serve()
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return l
+}
+
+func (l *UDPListener) serve() {
+	buf := make([]byte, 65536)
+	for {
+		n, err := l.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		l.mux.Lock()
+		l.packets = append(l.packets, string(buf[:n]))
+		l.mux.Unlock()
+	}
+}
+
+// Packets returns the datagrams received so far.
+func (l *UDPListener) Packets() []string {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return append([]string(nil), l.packets...)
+}
+
+// Lines returns the statsd lines received so far, datagrams carrying several metrics are split by newline.
+func (l *UDPListener) Lines() []string {
+	var lines []string
+	for _, p := range l.Packets() {
+		for _, line := range strings.Split(p, "\n") {
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+// WaitForLines waits until at least n lines are received and returns all of them.
+func (l *UDPListener) WaitForLines(t testing.TB, n int, timeout time.Duration) []string {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return len(l.Lines()) >= n
+	}, timeout, 5*time.Millisecond, "expected at least %d statsd lines, got %v", n, l.Lines())
+	return l.Lines()
+}
+
+// Reset drops the datagrams received so far.
+func (l *UDPListener) Reset() {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.packets = nil
+}
@@ -0,0 +1,86 @@
+package metricstest_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/InjectiveLabs/metrics"
+	"github.com/InjectiveLabs/metrics/metricstest"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestWireFormat(t *testing.T) {
+	for _, agent := range []string{metrics.DatadogAgent, metrics.TelegrafAgent} {
+		t.Run(agent, func(t *testing.T) {
+			listener := metricstest.NewUDPListener(t)
+
+			err := metrics.Init(listener.Addr, "svc.", &metrics.StatterConfig{
+				Agent:       agent,
+				EnvName:     "test",
+				HostName:    "host1",
+				DefaultTags: []interface{}{"chain", "injective-888"},
+			})
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				metrics.SetStatter(nil)
+			})
+
+			metrics.Counter("orders", 3, "market", "INJ/USDT")
+			metrics.Incr("orders.filled", "market", "INJ/USDT", "side", "buy")
+			metrics.Gauge("queue.size", 7.5, "queue", "default")
+			metrics.Timer("db.query", 15*time.Millisecond, metrics.Tags{"table": "orders"})
+			metrics.Histogram("batch.latency", 250*time.Millisecond, metrics.Tags{"stage": "submit"})
+
+			// closing the statter flushes buffered datagrams
+			metrics.Close()
+
+			lines := listener.WaitForLines(t, 5, 5*time.Second)
+			assertGolden(t, filepath.Join("testdata", agent+".golden"), normalizeLines(lines))
+		})
+	}
+}
+
+// normalizeLines sorts lines and the tags within them, since tags come from maps and their order is random.
+func normalizeLines(lines []string) string {
+	res := make([]string, 0, len(lines))
+	for _, line := range lines {
+		res = append(res, normalizeLine(line))
+	}
+	sort.Strings(res)
+	return strings.Join(res, "\n") + "\n"
+}
+
+func normalizeLine(line string) string {
+	// dogstatsd: name:value|type|#tag:a,tag:b
+	if idx := strings.Index(line, "|#"); idx >= 0 {
+		tags := strings.Split(line[idx+2:], ",")
+		sort.Strings(tags)
+		return line[:idx+2] + strings.Join(tags, ",")
+	}
+	// InfluxDB: name,tag=a,tag=b:value|type
+	if idx := strings.LastIndexByte(line, ':'); idx >= 0 {
+		parts := strings.Split(line[:idx], ",")
+		sort.Strings(parts[1:])
+		return strings.Join(parts, ",") + line[idx:]
+	}
+	return line
+}
+
+func assertGolden(t *testing.T, path, actual string) {
+	t.Helper()
+	if *update {
+		require.NoError(t, os.WriteFile(path, []byte(actual), 0644))
+	}
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err, "run go test -update to create golden files")
+	assert.Equal(t, string(expected), actual)
+}
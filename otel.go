@@ -14,6 +14,7 @@ import (
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/credentials"
 )
 
 type otelStatter struct {
@@ -76,29 +77,31 @@ func newOTELStatter(endpoint, prefix string, insecure bool, headers map[string]s
 	}, nil
 }
 
-func newOTELTracerProvider(endpoint string, insecure bool, headers map[string]string, baseTags []string) (*sdktrace.TracerProvider, error) {
-	ctx := context.Background()
-
+func newOTLPTraceExporter(endpoint string, cfg *StatterConfig) (sdktrace.SpanExporter, error) {
 	traceOpts := []otlptracegrpc.Option{
 		otlptracegrpc.WithEndpoint(endpoint),
 	}
-	if insecure {
+	if cfg.traceInsecure() {
 		//nolint:staticcheck
 		traceOpts = append(traceOpts, otlptracegrpc.WithInsecure())
+	} else if tlsCfg, err := cfg.traceTLSConfig(); err != nil {
+		return nil, err
+	} else if tlsCfg != nil {
+		traceOpts = append(traceOpts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsCfg)))
 	}
-	if len(headers) > 0 {
+	if headers := cfg.traceHeaders(); len(headers) > 0 {
 		traceOpts = append(traceOpts, otlptracegrpc.WithHeaders(headers))
 	}
 
-	exp, err := otlptracegrpc.New(ctx, traceOpts...)
-	if err != nil {
-		return nil, err
-	}
+	return otlptracegrpc.New(context.Background(), traceOpts...)
+}
 
+func newOTELTracerProvider(exp sdktrace.SpanExporter, cfg *StatterConfig, baseTags []string, sampler sdktrace.Sampler, batchOpts ...sdktrace.BatchSpanProcessorOption) *sdktrace.TracerProvider {
 	return sdktrace.NewTracerProvider(
 		sdktrace.WithResource(newOTELResource(baseTags)),
-		sdktrace.WithBatcher(exp),
-	), nil
+		sdktrace.WithSpanProcessor(newSpanProcessor(exp, cfg, batchOpts...)),
+		sdktrace.WithSampler(sampler),
+	)
 }
 
 // tagsToAttrs parses "key=value" tag strings into OTel attributes.
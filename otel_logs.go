@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/InjectiveLabs/suplog"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+)
+
+var (
+	otelLogs        = &otelLogHook{}
+	otelLogsHookSet sync.Once
+)
+
+func newOTELLoggerProvider(endpoint string, insecure bool, headers map[string]string, baseTags []string, maxQueueSize int) (*sdklog.LoggerProvider, error) {
+	ctx := context.Background()
+
+	logOpts := []otlploggrpc.Option{
+		otlploggrpc.WithEndpoint(endpoint),
+	}
+	if insecure {
+		logOpts = append(logOpts, otlploggrpc.WithInsecure())
+	}
+	if len(headers) > 0 {
+		logOpts = append(logOpts, otlploggrpc.WithHeaders(headers))
+	}
+
+	exp, err := otlploggrpc.New(ctx, logOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var batchOpts []sdklog.BatchProcessorOption
+	if maxQueueSize > 0 {
+		batchOpts = append(batchOpts, sdklog.WithMaxQueueSize(maxQueueSize))
+	}
+
+	return sdklog.NewLoggerProvider(
+		sdklog.WithResource(newOTELResource(baseTags)),
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(exp, batchOpts...)),
+	), nil
+}
+
+// setupOTELLogs makes the default suplog logger export its records through lp.
+// The hook is added once, later calls only replace the provider.
+func setupOTELLogs(lp *sdklog.LoggerProvider, scope string) {
+	otelLogs.setLogger(lp.Logger(scope))
+	otelLogsHookSet.Do(func() {
+		log.DefaultLogger.AddHook(otelLogs)
+	})
+}
+
+// otelLogHook is a logrus hook emitting every suplog entry as an OTLP log record. Records keep the span of
+// the entry context, e.g. when logged via Logger(ctx), so SigNoz can join them with traces.
+type otelLogHook struct {
+	logger atomic.Pointer[otellog.Logger]
+}
+
+func (h *otelLogHook) setLogger(l otellog.Logger) {
+	if l == nil {
+		h.logger.Store(nil)
+		return
+	}
+	h.logger.Store(&l)
+}
+
+func (h *otelLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *otelLogHook) Fire(entry *logrus.Entry) error {
+	l := h.logger.Load()
+	if l == nil {
+		return nil
+	}
+
+	var rec otellog.Record
+	rec.SetTimestamp(entry.Time)
+	rec.SetSeverity(otelSeverity(entry.Level))
+	rec.SetSeverityText(entry.Level.String())
+	rec.SetBody(otellog.StringValue(entry.Message))
+	for k, v := range entry.Data {
+		if k == logrus.ErrorKey {
+			k = "exception.message"
+		}
+		rec.AddAttributes(otellog.KeyValue{Key: k, Value: otelLogValue(v)})
+	}
+
+	ctx := entry.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	(*l).Emit(ctx, rec)
+	return nil
+}
+
+func otelSeverity(level logrus.Level) otellog.Severity {
+	switch level {
+	case logrus.PanicLevel:
+		return otellog.SeverityFatal2
+	case logrus.FatalLevel:
+		return otellog.SeverityFatal
+	case logrus.ErrorLevel:
+		return otellog.SeverityError
+	case logrus.WarnLevel:
+		return otellog.SeverityWarn
+	case logrus.InfoLevel:
+		return otellog.SeverityInfo
+	case logrus.DebugLevel:
+		return otellog.SeverityDebug
+	default:
+		return otellog.SeverityTrace
+	}
+}
+
+func otelLogValue(v interface{}) otellog.Value {
+	switch x := v.(type) {
+	case string:
+		return otellog.StringValue(x)
+	case bool:
+		return otellog.BoolValue(x)
+	case int:
+		return otellog.IntValue(x)
+	case int64:
+		return otellog.Int64Value(x)
+	case float64:
+		return otellog.Float64Value(x)
+	case error:
+		return otellog.StringValue(x.Error())
+	default:
+		if s, ok := ToString(v); ok {
+			return otellog.StringValue(s)
+		}
+		return otellog.StringValue(fmt.Sprint(v))
+	}
+}
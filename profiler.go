@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+)
+
+// slowProfilerDefaultMaxFiles bounds the disk used by captured profiles, a heap and a CPU profile count as two.
+const slowProfilerDefaultMaxFiles = 100
+
+// slowProfiler captures pprof CPU and heap profiles when a timed function is slow or stuck.
+// It doesn't depend on the metrics agent, so Telegraf- and OTEL-based deployments get profiling evidence too.
+//
+// Profiles are of the whole process, taken right after the call was detected as slow or stuck: for a slow
+// function it already returned, so the CPU profile shows what the process does next, e.g. the load that made
+// the call slow, whereas a stuck function is still running while its CPU profile is recorded.
+type slowProfiler struct {
+	sink        SnapshotSink
+	threshold   time.Duration
+	cpuDuration time.Duration
+	interval    time.Duration
+
+	lastCapture atomic.Int64 // unix nano of the last capture, shared by all functions
+}
+
+func newSlowProfiler(cfg *StatterConfig) *slowProfiler {
+	maxFiles := cfg.SlowProfileMaxFiles
+	if maxFiles == 0 {
+		maxFiles = slowProfilerDefaultMaxFiles
+	}
+	p := &slowProfiler{
+		threshold:   cfg.SlowFunctionThreshold,
+		cpuDuration: cfg.SlowProfileCPUDuration,
+		interval:    cfg.SlowProfileInterval,
+		sink:        NewDirSinkWithRetention(cfg.SlowProfileDir, maxFiles, 0),
+	}
+	if p.threshold <= 0 {
+		p.threshold = time.Second
+	}
+	if p.cpuDuration <= 0 {
+		p.cpuDuration = 5 * time.Second
+	}
+	if p.interval <= 0 {
+		p.interval = time.Minute
+	}
+	if cfg.SlowProfileDir == "" {
+		p.sink = NewDirSinkWithRetention("profiles", maxFiles, 0)
+	}
+	return p
+}
+
+// observe captures profiles if fn took longer than the configured threshold, ctx is the one of the timed call.
+func (p *slowProfiler) observe(ctx context.Context, fn string, d time.Duration) {
+	if p == nil || d < p.threshold {
+		return
+	}
+	p.capture(ctx, fn, d)
+}
+
+// capture takes a heap profile and a short CPU profile of the process in the background,
+// at most once per interval across the whole process.
+func (p *slowProfiler) capture(ctx context.Context, fn string, d time.Duration) {
+	if p == nil {
+		return
+	}
+
+	now := time.Now()
+	last := p.lastCapture.Load()
+	if now.UnixNano()-last < int64(p.interval) || !p.lastCapture.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	go func() {
+		logger := contextLogger(ctx)
+
+		var heap bytes.Buffer
+		if err := pprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+			logger.WithError(err).Warningln("failed to capture heap profile")
+		} else if err := p.sink.WriteSnapshot(newSnapshotMetadata(SnapshotKindHeap, fn, d, now), heap.Bytes()); err != nil {
+			logger.WithError(err).Warningln("failed to write heap profile")
+		}
+
+		var cpu bytes.Buffer
+		// fails when another CPU profile is already running, e.g. the Datadog profiler
+		if err := pprof.StartCPUProfile(&cpu); err != nil {
+			logger.WithError(err).Debugln("skipping CPU profile")
+			return
+		}
+		time.Sleep(p.cpuDuration)
+		pprof.StopCPUProfile()
+
+		if err := p.sink.WriteSnapshot(newSnapshotMetadata(SnapshotKindCPU, fn, d, now), cpu.Bytes()); err != nil {
+			logger.WithError(err).Warningln("failed to write CPU profile")
+		}
+	}()
+}
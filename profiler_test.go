@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlowProfilerCapturesSlowFunction(t *testing.T) {
+	record(t)
+
+	sink := &captureSink{}
+	profiler := newSlowProfiler(&StatterConfig{
+		SlowFunctionThreshold:  5 * time.Millisecond,
+		SlowProfileCPUDuration: 10 * time.Millisecond,
+		SlowProfileInterval:    time.Hour,
+	})
+	profiler.sink = sink
+
+	clientMux.Lock()
+	localProfiler = profiler
+	clientMux.Unlock()
+	t.Cleanup(func() {
+		clientMux.Lock()
+		localProfiler = nil
+		clientMux.Unlock()
+	})
+
+	profiledFunc(0)
+	profiledFunc(10 * time.Millisecond)
+	// rate-limited, so the second slow call doesn't capture anything
+	profiledFunc(10 * time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		sink.mux.Lock()
+		defer sink.mux.Unlock()
+		return len(sink.snapshots) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	sink.mux.Lock()
+	defer sink.mux.Unlock()
+	require.Len(t, sink.snapshots, 2)
+
+	kinds := []string{sink.snapshots[0].Kind, sink.snapshots[1].Kind}
+	assert.ElementsMatch(t, []string{SnapshotKindHeap, SnapshotKindCPU}, kinds)
+	for i, meta := range sink.snapshots {
+		assert.Equal(t, "profiledFunc", meta.Region)
+		assert.GreaterOrEqual(t, meta.Duration, 10*time.Millisecond)
+		assert.NotEmpty(t, sink.data[i])
+	}
+}
+
+func profiledFunc(d time.Duration) {
+	defer ReportFuncTiming()()
+	time.Sleep(d)
+}
+
+func TestSlowProfilerDisabled(t *testing.T) {
+	var p *slowProfiler
+	assert.NotPanics(t, func() {
+		p.observe(context.Background(), "fn", time.Hour)
+		p.capture(context.Background(), "fn", time.Hour)
+	})
+}
+
+func labeledFunc(ctx context.Context) context.Context {
+	ctx, stop := ReportFuncTimingCtx(ctx, Tags{"market": "INJ/USDT", "ignored": "x"})
+	defer stop()
+	return ctx
+}
+
+func TestPprofLabels(t *testing.T) {
+	record(t)
+	config.PprofLabelsEnabled = true
+	config.PprofLabelTags = []string{"market"}
+
+	ctx := labeledFunc(context.Background())
+
+	fn, ok := pprof.Label(ctx, "func_name")
+	assert.True(t, ok)
+	assert.Equal(t, "labeledFunc", fn)
+	market, ok := pprof.Label(ctx, "market")
+	assert.True(t, ok)
+	assert.Equal(t, "INJ/USDT", market)
+	_, ok = pprof.Label(ctx, "ignored")
+	assert.False(t, ok)
+
+	config.PprofLabelsEnabled = false
+	ctx = labeledFunc(context.Background())
+	_, ok = pprof.Label(ctx, "func_name")
+	assert.False(t, ok)
+}
+
+// goroutineLabels returns the pprof labels of the goroutine running fn, as printed by the goroutine profile.
+func goroutineLabels(t *testing.T, fn string) string {
+	var buf bytes.Buffer
+	require.NoError(t, pprof.Lookup("goroutine").WriteTo(&buf, 1))
+	for _, record := range strings.Split(buf.String(), "\n\n") {
+		if !strings.Contains(record, fn) {
+			continue
+		}
+		for _, line := range strings.Split(record, "\n") {
+			if labels, ok := strings.CutPrefix(line, "# labels: "); ok {
+				return labels
+			}
+		}
+		return ""
+	}
+	t.Fatalf("goroutine of %s not found", fn)
+	return ""
+}
+
+func TestPprofLabelsNested(t *testing.T) {
+	record(t)
+	config.PprofLabelsEnabled = true
+	config.PprofLabelTags = []string{"market"}
+
+	ctx, stopOuter := ReportFuncTimingCtx(context.Background(), Tags{"market": "INJ/USDT"})
+	labeledFunc(ctx)
+	assert.Equal(t, `{"func_name":"TestPprofLabelsNested", "market":"INJ/USDT"}`, goroutineLabels(t, "TestPprofLabelsNested"))
+
+	// stopping on another goroutine leaves the labels of both alone, the new one inherited the inner labels
+	_, stopInner := ReportFuncTimingCtx(ctx, Tags{"market": "ATOM/USDT"})
+	inner := `{"func_name":"TestPprofLabelsNested", "market":"ATOM/USDT"}`
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stopInner()
+		assert.Equal(t, inner, goroutineLabels(t, "TestPprofLabelsNested.func1"))
+	}()
+	<-done
+	assert.Equal(t, inner, goroutineLabels(t, "TestPprofLabelsNested"))
+
+	stopOuter()
+	assert.Equal(t, "", goroutineLabels(t, "TestPprofLabelsNested"))
+}
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc/metadata"
+)
+
+// Helpers below carry the span and baggage of ctx across process boundaries using the propagator
+// installed by Init (W3C TraceContext and Baggage), for both the Datadog and the OTEL tracer.
+// They are no-ops when tracing is disabled.
+
+// MessageHeader is a single header of a message bus record, e.g. NATS or Kafka.
+type MessageHeader struct {
+	Key   string
+	Value []byte
+}
+
+// newTextMapPropagator is the propagator installed by Init for both tracer providers,
+// ddotel spans accept W3C trace contexts as remote parents.
+func newTextMapPropagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	)
+}
+
+// Inject writes the trace context of ctx into the carrier.
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+}
+
+// Extract returns ctx with the remote trace context read from the carrier,
+// spans started from it continue the caller's trace.
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// InjectMap writes the trace context of ctx into m.
+func InjectMap(ctx context.Context, m map[string]string) {
+	Inject(ctx, propagation.MapCarrier(m))
+}
+
+// ExtractMap returns ctx with the trace context read from m.
+func ExtractMap(ctx context.Context, m map[string]string) context.Context {
+	return Extract(ctx, propagation.MapCarrier(m))
+}
+
+// InjectHTTP writes the trace context of ctx into outgoing request headers.
+func InjectHTTP(ctx context.Context, h http.Header) {
+	Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// ExtractHTTP returns ctx with the trace context read from incoming request headers.
+func ExtractHTTP(ctx context.Context, h http.Header) context.Context {
+	return Extract(ctx, propagation.HeaderCarrier(h))
+}
+
+// InjectGRPC returns ctx with the trace context of ctx appended to the outgoing gRPC metadata.
+func InjectGRPC(ctx context.Context) context.Context {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// ExtractGRPC returns ctx with the trace context read from the incoming gRPC metadata.
+func ExtractGRPC(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return Extract(ctx, metadataCarrier(md))
+}
+
+// InjectMessageHeaders returns headers with the trace context of ctx added, replacing existing trace headers.
+func InjectMessageHeaders(ctx context.Context, headers []MessageHeader) []MessageHeader {
+	carrier := messageHeaderCarrier{headers: headers}
+	Inject(ctx, &carrier)
+	return carrier.headers
+}
+
+// ExtractMessageHeaders returns ctx with the trace context read from message headers.
+func ExtractMessageHeaders(ctx context.Context, headers []MessageHeader) context.Context {
+	return Extract(ctx, &messageHeaderCarrier{headers: headers})
+}
+
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+type messageHeaderCarrier struct {
+	headers []MessageHeader
+}
+
+func (c *messageHeaderCarrier) Get(key string) string {
+	for _, h := range c.headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c *messageHeaderCarrier) Set(key, value string) {
+	for i, h := range c.headers {
+		if h.Key == key {
+			c.headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.headers = append(c.headers, MessageHeader{Key: key, Value: []byte(value)})
+}
+
+func (c *messageHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.headers))
+	for _, h := range c.headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
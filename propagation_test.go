@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestPropagation(t *testing.T) {
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(newTextMapPropagator())
+	t.Cleanup(func() {
+		otel.SetTextMapPropagator(prev)
+	})
+
+	tp := sdktrace.NewTracerProvider()
+	ctx, span := tp.Tracer("test").Start(context.Background(), "producer")
+	defer span.End()
+	expected := span.SpanContext()
+
+	assertRemoteParent := func(t *testing.T, ctx context.Context) {
+		sc := trace.SpanContextFromContext(ctx)
+		assert.True(t, sc.IsRemote())
+		assert.Equal(t, expected.TraceID(), sc.TraceID())
+		assert.Equal(t, expected.SpanID(), sc.SpanID())
+	}
+
+	t.Run("map", func(t *testing.T) {
+		m := map[string]string{}
+		InjectMap(ctx, m)
+		require.Contains(t, m, "traceparent")
+		assertRemoteParent(t, ExtractMap(context.Background(), m))
+	})
+
+	t.Run("http", func(t *testing.T) {
+		h := http.Header{}
+		InjectHTTP(ctx, h)
+		assertRemoteParent(t, ExtractHTTP(context.Background(), h))
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		outCtx := metadata.AppendToOutgoingContext(ctx, "x-request-id", "42")
+		outCtx = InjectGRPC(outCtx)
+		md, _ := metadata.FromOutgoingContext(outCtx)
+		assert.Equal(t, []string{"42"}, md.Get("x-request-id"))
+
+		inCtx := metadata.NewIncomingContext(context.Background(), md)
+		assertRemoteParent(t, ExtractGRPC(inCtx))
+	})
+
+	t.Run("message headers", func(t *testing.T) {
+		headers := []MessageHeader{
+			{Key: "content-type", Value: []byte("application/json")},
+			{Key: "traceparent", Value: []byte("stale")},
+		}
+		headers = InjectMessageHeaders(ctx, headers)
+		assert.Len(t, headers, 2)
+		assertRemoteParent(t, ExtractMessageHeaders(context.Background(), headers))
+	})
+}
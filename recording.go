@@ -0,0 +1,272 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// recordingFlushInterval bounds how many calls are lost if the process crashes.
+const recordingFlushInterval = time.Second
+
+const (
+	RecordedCount     = "count"
+	RecordedIncr      = "incr"
+	RecordedDecr      = "decr"
+	RecordedGauge     = "gauge"
+	RecordedTiming    = "timing"
+	RecordedHistogram = "histogram"
+)
+
+// RecordedCall is a single Statter call as stored by the recording statter, one JSON object per line.
+// Names are stored without the metrics prefix and tags without the base tags of the agent.
+type RecordedCall struct {
+	Time   time.Time         `json:"time"`
+	Method string            `json:"method"`
+	Name   string            `json:"name"`
+	Value  float64           `json:"value"` // delta, gauge or histogram value, nanoseconds for timings
+	Tags   map[string]string `json:"tags,omitempty"`
+	Rate   float64           `json:"rate,omitempty"`
+}
+
+// recordingStatter appends every call to a JSONL file, see StatterConfig.RecordFile.
+type recordingStatter struct {
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+	sep byte // between key and value of the tags, see getSingleTag
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRecordingStatter returns a Statter appending every call to the JSONL file at path, the file can be loaded into
+// any agent later with Replay or cmd/metrics-replay. Calls are buffered and written every second and on Close.
+// Tags are split in the format of agent, the one they are joined for, so values may contain the other separator.
+func NewRecordingStatter(path, agent string) (Statter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	s := &recordingStatter{
+		f:    f,
+		w:    w,
+		enc:  json.NewEncoder(w),
+		sep:  '=',
+		done: make(chan struct{}),
+	}
+	if agent == DatadogAgent {
+		s.sep = ':'
+	}
+	go s.flushLoop()
+	return s, nil
+}
+
+func (s *recordingStatter) flushLoop() {
+	ticker := time.NewTicker(recordingFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			_ = s.w.Flush()
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *recordingStatter) record(method, name string, value float64, tags []string, rate float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(RecordedCall{
+		Time:   time.Now(),
+		Method: method,
+		Name:   name,
+		Value:  value,
+		Tags:   splitTags(tags, s.sep),
+		Rate:   rate,
+	})
+}
+
+func (s *recordingStatter) Count(name string, value int64, tags []string, rate float64) error {
+	return s.record(RecordedCount, name, float64(value), tags, rate)
+}
+
+func (s *recordingStatter) Incr(name string, tags []string, rate float64) error {
+	return s.record(RecordedIncr, name, 1, tags, rate)
+}
+
+func (s *recordingStatter) Decr(name string, tags []string, rate float64) error {
+	return s.record(RecordedDecr, name, -1, tags, rate)
+}
+
+func (s *recordingStatter) Gauge(name string, value float64, tags []string, rate float64) error {
+	return s.record(RecordedGauge, name, value, tags, rate)
+}
+
+func (s *recordingStatter) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	return s.record(RecordedTiming, name, float64(value), tags, rate)
+}
+
+func (s *recordingStatter) Histogram(name string, value float64, tags []string, rate float64) error {
+	return s.record(RecordedHistogram, name, value, tags, rate)
+}
+
+func (s *recordingStatter) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err = s.w.Flush(); err != nil {
+			_ = s.f.Close()
+			return
+		}
+		err = s.f.Close()
+	})
+	return err
+}
+
+// splitTags converts "key=value" (Telegraf/OTEL) or "key:value" (Datadog) tags into a map, splitting on sep only.
+func splitTags(tags []string, sep byte) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		if idx := strings.IndexByte(tag, sep); idx > 0 {
+			res[tag[:idx]] = tag[idx+1:]
+		}
+	}
+	return res
+}
+
+// teeStatter sends every call to both statters, e.g. the agent and the recording statter.
+type teeStatter struct {
+	primary, secondary Statter
+}
+
+func (t *teeStatter) Count(name string, value int64, tags []string, rate float64) error {
+	return firstErr(t.primary.Count(name, value, tags, rate), t.secondary.Count(name, value, tags, rate))
+}
+
+func (t *teeStatter) Incr(name string, tags []string, rate float64) error {
+	return firstErr(t.primary.Incr(name, tags, rate), t.secondary.Incr(name, tags, rate))
+}
+
+func (t *teeStatter) Decr(name string, tags []string, rate float64) error {
+	return firstErr(t.primary.Decr(name, tags, rate), t.secondary.Decr(name, tags, rate))
+}
+
+func (t *teeStatter) Gauge(name string, value float64, tags []string, rate float64) error {
+	return firstErr(t.primary.Gauge(name, value, tags, rate), t.secondary.Gauge(name, value, tags, rate))
+}
+
+func (t *teeStatter) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	return firstErr(t.primary.Timing(name, value, tags, rate), t.secondary.Timing(name, value, tags, rate))
+}
+
+func (t *teeStatter) Histogram(name string, value float64, tags []string, rate float64) error {
+	return firstErr(t.primary.Histogram(name, value, tags, rate), t.secondary.Histogram(name, value, tags, rate))
+}
+
+func (t *teeStatter) Close() error {
+	return firstErr(t.primary.Close(), t.secondary.Close())
+}
+
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replay sends calls read from a recording to the package-level statter, so Init has to be called first.
+// With realtime enabled, calls are spaced out like they were recorded, otherwise they are sent as fast as possible.
+// Replay stops when ctx is done. An invalid last line is skipped, since it's what a crash while recording leaves.
+func Replay(ctx context.Context, r io.Reader, realtime bool) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var (
+		replayed int
+		line     int
+		first    time.Time
+		start    = time.Now()
+		invalid  error // of the previous line, only an error if it isn't the last one
+	)
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		if invalid != nil {
+			return replayed, invalid
+		}
+		var call RecordedCall
+		if err := json.Unmarshal(scanner.Bytes(), &call); err != nil {
+			invalid = errors.Wrapf(err, "invalid recorded call on line %d", line)
+			continue
+		}
+
+		switch call.Method {
+		case RecordedCount, RecordedIncr, RecordedDecr, RecordedGauge, RecordedTiming, RecordedHistogram:
+		default:
+			return replayed, errors.Errorf("unknown method %q on line %d", call.Method, line)
+		}
+
+		if realtime {
+			if first.IsZero() {
+				first = call.Time
+			}
+			if wait := call.Time.Sub(first) - time.Since(start); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return replayed, ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+
+		rate := call.Rate
+		if rate == 0 {
+			rate = 1
+		}
+		CustomReport(func(s Statter, tagSpec []string) {
+			switch call.Method {
+			case RecordedCount:
+				s.Count(call.Name, int64(call.Value), tagSpec, rate)
+			case RecordedIncr:
+				s.Incr(call.Name, tagSpec, rate)
+			case RecordedDecr:
+				s.Decr(call.Name, tagSpec, rate)
+			case RecordedGauge:
+				s.Gauge(call.Name, call.Value, tagSpec, rate)
+			case RecordedTiming:
+				s.Timing(call.Name, time.Duration(call.Value), tagSpec, rate)
+			case RecordedHistogram:
+				s.Histogram(call.Name, call.Value, tagSpec, rate)
+			}
+		}, call.Tags)
+		replayed++
+	}
+	return replayed, scanner.Err()
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	s, err := NewRecordingStatter(path, TelegrafAgent)
+	require.NoError(t, err)
+
+	tags := []string{"market=INJ/USDT", "side=buy"}
+	require.NoError(t, s.Count("orders.placed", 3, tags, 1))
+	require.NoError(t, s.Incr("orders.matched", nil, 0.5))
+	require.NoError(t, s.Gauge("orderbook.depth", 12.5, tags, 1))
+	require.NoError(t, s.Timing("func.timing", 15*time.Millisecond, tags, 1))
+	require.NoError(t, s.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 4)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	rec := record(t)
+	replayed, err := Replay(context.Background(), f, false)
+	require.NoError(t, err)
+	assert.Equal(t, 4, replayed)
+	require.Len(t, rec.calls, 4)
+
+	assert.Equal(t, []interface{}{"Count", "orders.placed", int64(3)}, rec.calls[0][:3])
+	assert.Subset(t, rec.calls[0][3], []string{"market=INJ/USDT", "side=buy"})
+	assert.Equal(t, []interface{}{"Incr", "orders.matched"}, rec.calls[1][:2])
+	assert.Equal(t, 0.5, rec.calls[1][3])
+	assert.Equal(t, []interface{}{"Gauge", "orderbook.depth", 12.5}, rec.calls[2][:3])
+	assert.Equal(t, []interface{}{"Timing", "func.timing", 15 * time.Millisecond}, rec.calls[3][:3])
+}
+
+func TestRecordingDatadogTags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	s, err := NewRecordingStatter(path, DatadogAgent)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Incr("http.requests", []string{"url:/x?a=b", "time:12:30"}, 1))
+	require.NoError(t, s.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	rec := record(t)
+	_, err = Replay(context.Background(), f, false)
+	require.NoError(t, err)
+	require.Len(t, rec.calls, 1)
+	assert.Subset(t, rec.calls[0][2], []string{"url=/x?a=b", "time=12:30"})
+}
+
+func TestReplayInvalidLine(t *testing.T) {
+	record(t)
+	in := strings.NewReader(`{"method":"incr","name":"a"}` + "\n\n" + `{"method":"set","name":"b"}` + "\n")
+	replayed, err := Replay(context.Background(), in, false)
+	assert.Equal(t, 1, replayed)
+	assert.EqualError(t, err, `unknown method "set" on line 3`)
+}
+
+func TestReplayTruncatedLine(t *testing.T) {
+	record(t)
+	in := strings.NewReader(`{"method":"incr","name":"a"}` + "\n" + `{"method":"incr","na`)
+	replayed, err := Replay(context.Background(), in, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+
+	in = strings.NewReader(`{"method":"incr","na` + "\n" + `{"method":"incr","name":"a"}` + "\n")
+	replayed, err = Replay(context.Background(), in, false)
+	assert.ErrorContains(t, err, "invalid recorded call on line 1")
+	assert.Equal(t, 0, replayed)
+}
+
+func TestRecordingFlushedPeriodically(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	s, err := NewRecordingStatter(path, TelegrafAgent)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.Incr("orders.matched", nil, 1))
+	assert.Eventually(t, func() bool {
+		data, err := os.ReadFile(path)
+		return err == nil && strings.HasSuffix(string(data), "\n")
+	}, 3*recordingFlushInterval, 50*time.Millisecond)
+}
@@ -74,28 +74,32 @@ func Histogram(metric string, value time.Duration, tags ...Tags) {
 
 // Timing supports both Tags or pairs of key-value arguments.
 func Timing(metric string, initialTags ...interface{}) func(deferredTags ...interface{}) {
-	start := time.Now()
-	it := Combine(initialTags...)
-	return func(deferredTags ...interface{}) {
-		dt := Combine(deferredTags...)
-		Timer(metric, time.Since(start), MergeTags(it, dt))
+	_, stop := TimingCtx(context.Background(), metric, initialTags...)
+	return stop
+}
+
+// TimingCtx is Timing with a child span of ctx, the returned context carries the span.
+func TimingCtx(ctx context.Context, metric string, initialTags ...interface{}) (context.Context, func(deferredTags ...interface{})) {
+	spanCtx, stop := startMetricTimer(ctx, metric, Combine(initialTags...))
+	return spanCtx, func(deferredTags ...interface{}) {
+		stop(nil, Combine(deferredTags...))
 	}
 }
 
 // TimingWithErr supports both Tags or pairs of key-value arguments.
 func TimingWithErr(metric string, initialTags ...interface{}) func(err *error, deferredTags ...interface{}) {
-	stop := Timing(metric, initialTags...)
+	return TimingCtxWithErr(context.Background(), metric, initialTags...)
+}
+
+// TimingCtxWithErr supports both Tags or pairs of key-value arguments. The timer runs as a child span of ctx.
+func TimingCtxWithErr(ctx context.Context, metric string, initialTags ...interface{}) func(err *error, deferredTags ...interface{}) {
+	_, stop := startMetricTimer(ctx, metric, Combine(initialTags...))
 	return func(err *error, deferredTags ...interface{}) {
 		dt := append(deferredTags, "error", BoolTag(err != nil && *err != nil))
-		stop(dt...)
+		stop(derefErr(err), Combine(dt...))
 	}
 }
 
-// TimingCtxWithErr supports both Tags or pairs of key-value arguments.
-func TimingCtxWithErr(_ context.Context, metric string, initialTags ...interface{}) func(err *error, deferredTags ...interface{}) {
-	return TimingWithErr(metric, initialTags...)
-}
-
 func Gauge(metric string, value float64, tags ...interface{}) {
 	CustomReport(func(s Statter, tagSpec []string) {
 		s.Gauge(metric, value, tagSpec, 1)
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// samplingStatter applies sample rates to every call, using SampleRates of the metric name when configured and
+// the rate passed by the caller otherwise. Rates outside (0, 1) send every call.
+//
+// Statsd clients (Datadog and Telegraf without aggregation) drop calls by themselves and annotate the sent ones
+// with @rate, so the agent scales them up, the rate is only passed on to them. For other backends calls are
+// dropped here and counts of the kept ones are scaled up by 1/rate; gauges, timings and histograms are passed
+// with their rate, so backends counting them (aggregated Telegraf, Graphite, InfluxDB) can account for the dropped ones.
+type samplingStatter struct {
+	next   Statter
+	rates  map[string]float64
+	native bool // whether next samples by itself, see above
+}
+
+// defaultSampleRates keeps the rate func.called and func.error were always sent with, SampleRates entries override them.
+var defaultSampleRates = map[string]float64{
+	"func.called": 0.77,
+	"func.error":  0.77,
+}
+
+// sampleRates returns defaultSampleRates merged with SampleRates.
+func (m *StatterConfig) sampleRates() map[string]float64 {
+	rates := make(map[string]float64, len(defaultSampleRates)+len(m.SampleRates))
+	for name, rate := range defaultSampleRates {
+		rates[name] = rate
+	}
+	for name, rate := range m.SampleRates {
+		rates[name] = rate
+	}
+	return rates
+}
+
+func newSamplingStatter(next Statter, rates map[string]float64, native bool) *samplingStatter {
+	return &samplingStatter{
+		next:   next,
+		rates:  rates,
+		native: native,
+	}
+}
+
+func (s *samplingStatter) rate(name string, rate float64) float64 {
+	if r, ok := s.rates[name]; ok {
+		rate = r
+	}
+	if rate <= 0 || rate >= 1 {
+		return 1
+	}
+	return rate
+}
+
+// drop decides whether a call sampled at rate is dropped, it's never the case for native sampling.
+func (s *samplingStatter) drop(rate float64) bool {
+	return !s.native && rate < 1 && rand.Float64() >= rate
+}
+
+// scaleCount returns value/rate, rounded up or down at random so the expected sum is exact.
+func scaleCount(value int64, rate float64) int64 {
+	scaled := float64(value) / rate
+	n := math.Floor(scaled)
+	if rand.Float64() < scaled-n {
+		n++
+	}
+	return int64(n)
+}
+
+func (s *samplingStatter) count(name string, value int64, tags []string, rate float64, send func(rate float64) error) error {
+	rate = s.rate(name, rate)
+	if s.native || rate == 1 {
+		return send(rate)
+	}
+	if s.drop(rate) {
+		return nil
+	}
+	return s.next.Count(name, scaleCount(value, rate), tags, 1)
+}
+
+func (s *samplingStatter) Count(name string, value int64, tags []string, rate float64) error {
+	return s.count(name, value, tags, rate, func(rate float64) error {
+		return s.next.Count(name, value, tags, rate)
+	})
+}
+
+func (s *samplingStatter) Incr(name string, tags []string, rate float64) error {
+	return s.count(name, 1, tags, rate, func(rate float64) error {
+		return s.next.Incr(name, tags, rate)
+	})
+}
+
+func (s *samplingStatter) Decr(name string, tags []string, rate float64) error {
+	return s.count(name, -1, tags, rate, func(rate float64) error {
+		return s.next.Decr(name, tags, rate)
+	})
+}
+
+func (s *samplingStatter) Gauge(name string, value float64, tags []string, rate float64) error {
+	rate = s.rate(name, rate)
+	if s.drop(rate) {
+		return nil
+	}
+	return s.next.Gauge(name, value, tags, rate)
+}
+
+func (s *samplingStatter) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	rate = s.rate(name, rate)
+	if s.drop(rate) {
+		return nil
+	}
+	return s.next.Timing(name, value, tags, rate)
+}
+
+func (s *samplingStatter) Histogram(name string, value float64, tags []string, rate float64) error {
+	rate = s.rate(name, rate)
+	if s.drop(rate) {
+		return nil
+	}
+	return s.next.Histogram(name, value, tags, rate)
+}
+
+func (s *samplingStatter) Close() error {
+	return s.next.Close()
+}
+
+// unwrapStatter returns the agent statter behind the sampling and recording statters installed by Init.
+func unwrapStatter(s Statter) Statter {
+	for {
+		switch w := s.(type) {
+		case *samplingStatter:
+			s = w.next
+		case *teeStatter:
+			s = w.primary
+		default:
+			return s
+		}
+	}
+}
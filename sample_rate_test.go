@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSamplingStatter(t *testing.T) {
+	t.Run("dropped and scaled", func(t *testing.T) {
+		var rec statterRecorder
+		s := newSamplingStatter(&rec, map[string]float64{"orders": 0.25}, false)
+
+		const calls = 20000
+		for i := 0; i < calls; i++ {
+			s.Incr("orders", nil, 1)
+			s.Incr("other", nil, 1)
+		}
+
+		var orders, other int64
+		for _, call := range rec.calls {
+			switch call[1] {
+			case "orders":
+				assert.Equal(t, "Count", call[0])
+				assert.Equal(t, 1.0, call[4])
+				orders += call[2].(int64)
+			case "other":
+				other++
+			}
+		}
+		assert.Equal(t, int64(calls), other)
+		assert.InDelta(t, calls, orders, calls*0.1)
+		assert.Less(t, len(rec.calls), calls+calls/2)
+	})
+
+	t.Run("sampled timings keep their rate", func(t *testing.T) {
+		var rec statterRecorder
+		s := newSamplingStatter(&rec, map[string]float64{"db.query": 0.5}, false)
+
+		for i := 0; i < 100; i++ {
+			s.Timing("db.query", time.Millisecond, nil, 1)
+		}
+		require.NotEmpty(t, rec.calls)
+		assert.Less(t, len(rec.calls), 100)
+		for _, call := range rec.calls {
+			assert.Equal(t, 0.5, call[4])
+		}
+	})
+
+	t.Run("func calls sampled by default", func(t *testing.T) {
+		cfg := &StatterConfig{SampleRates: map[string]float64{"func.error": 1}}
+		assert.Equal(t, map[string]float64{"func.called": 0.77, "func.error": 1}, cfg.sampleRates())
+	})
+
+	t.Run("passed to native sampling", func(t *testing.T) {
+		var rec statterRecorder
+		s := newSamplingStatter(&rec, map[string]float64{"orders": 0.25}, true)
+
+		s.Incr("orders", nil, 1)
+		s.Timing("db.query", time.Millisecond, nil, 0.5)
+		s.Gauge("queue.size", 1, nil, 2)
+		assert.Equal(t, [][]interface{}{
+			{"Incr", "orders", []string(nil), 0.25},
+			{"Timing", "db.query", time.Millisecond, []string(nil), 0.5},
+			{"Gauge", "queue.size", 1.0, []string(nil), 1.0},
+		}, rec.calls)
+	})
+}
+
+func TestTelegrafSampleRate(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	restoreStatter := SetStatter(nil)
+	t.Cleanup(restoreStatter)
+	err = Init(conn.LocalAddr().String(), "svc.", &StatterConfig{
+		Agent:       TelegrafAgent,
+		EnvName:     "test",
+		SampleRates: map[string]float64{"orders": 0.5},
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		Counter("orders", 1)
+	}
+	Close()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 64*1024)
+	var n int
+	for n == 0 {
+		// the statsd client probes the address with empty datagrams first
+		n, _, err = conn.ReadFrom(buf)
+		require.NoError(t, err)
+	}
+	lines := strings.Split(string(buf[:n]), "\n")
+	assert.Less(t, len(lines), 100)
+	for _, line := range lines {
+		assert.Equal(t, "svc.orders,env=test:1|c|@0.5", line)
+	}
+}
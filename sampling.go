@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/time/rate"
+)
+
+const samplingReportInterval = 10 * time.Second
+
+// newTraceSampler builds the sampler of the OTEL tracer provider from the config. Child spans follow
+// the decision of their parent, root spans are sampled by name override or TraceSampleRatio
+// and then capped by TraceSampleRateLimit.
+func newTraceSampler(cfg *StatterConfig) *rootSampler {
+	ratio := cfg.TraceSampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+
+	s := &rootSampler{
+		defaultSampler: sdktrace.TraceIDRatioBased(ratio),
+		overrides:      make(map[string]sdktrace.Sampler, len(cfg.TraceSampleOverrides)),
+	}
+	for name, r := range cfg.TraceSampleOverrides {
+		s.overrides[name] = sdktrace.TraceIDRatioBased(r)
+	}
+	if cfg.TraceSampleRateLimit > 0 {
+		burst := int(cfg.TraceSampleRateLimit)
+		if burst < 1 {
+			burst = 1
+		}
+		s.limiter = rate.NewLimiter(rate.Limit(cfg.TraceSampleRateLimit), burst)
+	}
+	return s
+}
+
+// rootSampler samples root spans. Decisions are counted in atomics and reported as trace.sampling
+// by reportLoop, since samplers run while timers hold clientMux. It isn't reported with the stdout agent.
+type rootSampler struct {
+	defaultSampler sdktrace.Sampler
+	overrides      map[string]sdktrace.Sampler
+	limiter        *rate.Limiter
+
+	sampled     atomic.Int64
+	dropped     atomic.Int64
+	rateLimited atomic.Int64
+}
+
+func (s *rootSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	sampler, ok := s.overrides[p.Name]
+	if !ok {
+		sampler = s.defaultSampler
+	}
+
+	res := sampler.ShouldSample(p)
+	if res.Decision != sdktrace.RecordAndSample {
+		s.dropped.Add(1)
+		return res
+	}
+	if s.limiter != nil && !s.limiter.Allow() {
+		s.rateLimited.Add(1)
+		res.Decision = sdktrace.Drop
+		return res
+	}
+	s.sampled.Add(1)
+	return res
+}
+
+func (s *rootSampler) Description() string {
+	return "RootSampler{" + s.defaultSampler.Description() + "}"
+}
+
+// reportLoop reports sampling decisions made since the last report until stop is closed.
+func (s *rootSampler) reportLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(samplingReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			s.report()
+			return
+		case <-ticker.C:
+			s.report()
+		}
+	}
+}
+
+func (s *rootSampler) report() {
+	for decision, counter := range map[string]*atomic.Int64{
+		"sampled":      &s.sampled,
+		"dropped":      &s.dropped,
+		"rate_limited": &s.rateLimited,
+	} {
+		if n := counter.Swap(0); n > 0 {
+			Counter("trace.sampling", n, "decision", decision)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestTraceSampler(t *testing.T) {
+	rec := record(t)
+
+	sampler := newTraceSampler(&StatterConfig{
+		TraceSampleOverrides: map[string]float64{"noisy": 0},
+		TraceSampleRateLimit: 1,
+	})
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.ParentBased(sampler))).Tracer("test")
+
+	_, noisy := tracer.Start(context.Background(), "noisy")
+	assert.False(t, noisy.SpanContext().IsSampled())
+
+	ctx, first := tracer.Start(context.Background(), "first")
+	assert.True(t, first.SpanContext().IsSampled())
+	_, child := tracer.Start(ctx, "noisy")
+	assert.True(t, child.SpanContext().IsSampled(), "child spans follow the parent decision")
+
+	_, second := tracer.Start(context.Background(), "second")
+	assert.False(t, second.SpanContext().IsSampled(), "rate limit allows a single root span per second")
+
+	sampler.report()
+	counts := make(map[string]int64)
+	for _, call := range rec.calls {
+		if call[1] == "trace.sampling" {
+			for _, tag := range call[3].([]string) {
+				counts[tag] += call[2].(int64)
+			}
+		}
+	}
+	assert.Equal(t, map[string]int64{"decision=sampled": 1, "decision=dropped": 1, "decision=rate_limited": 1}, counts)
+}
@@ -0,0 +1,85 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/InjectiveLabs/metrics"
+	"github.com/InjectiveLabs/metrics/metricstest"
+)
+
+func failingFunc(ctx context.Context, fail bool) (err error) {
+	defer metrics.ReportFuncCallAndTimingCtxWithErr(ctx, metrics.Tags{"market": "INJ/USDT", "batch": "12"})(&err, metrics.Tags{"retried": "true", "ratio": "0.5"})
+	if fail {
+		return errors.New("order rejected")
+	}
+	return nil
+}
+
+func TestReportFuncCallAndTimingCtxWithErrSpan(t *testing.T) {
+	rec := metricstest.New(t)
+	spans := metricstest.RecordSpans(t)
+
+	require.Error(t, failingFunc(context.Background(), true))
+	rec.AssertCount(t, "func.error", 1)
+	spans.AssertStatus(t, "failingFunc", codes.Error)
+
+	span := spans.Named("failingFunc")[0]
+	assert.Equal(t, "order rejected", span.StatusDescription)
+	assert.Contains(t, span.Events, "exception")
+	assert.Equal(t, "INJ/USDT", span.Attributes["market"])
+	assert.Equal(t, int64(12), span.Attributes["batch"])
+	assert.Equal(t, true, span.Attributes["retried"])
+	assert.Equal(t, 0.5, span.Attributes["ratio"])
+
+	spans.Reset()
+	require.NoError(t, failingFunc(context.Background(), false))
+	spans.AssertStatus(t, "failingFunc", codes.Unset)
+	assert.Empty(t, spans.Named("failingFunc")[0].Events)
+}
+
+func TestTimingSpans(t *testing.T) {
+	rec := metricstest.New(t)
+	spans := metricstest.RecordSpans(t)
+
+	ctx, stop := metrics.TimingCtx(context.Background(), "batch.process", "size", 10)
+	func() (err error) {
+		defer metrics.TimingCtxWithErr(ctx, "db.query", "table", "orders")(&err)
+		return errors.New("deadlock")
+	}()
+	metrics.ReportClosureFuncTiming("closure")()
+	stop()
+
+	spans.AssertChildOf(t, "db.query", "batch.process")
+	spans.AssertStatus(t, "db.query", codes.Error)
+	spans.AssertStatus(t, "batch.process", codes.Unset)
+	assert.Equal(t, int64(10), spans.Named("batch.process")[0].Attributes["size"])
+	assert.Equal(t, "orders", spans.Named("db.query")[0].Attributes["table"])
+	assert.Len(t, spans.Named("closure"), 1)
+
+	rec.AssertCalled(t, "db.query", metrics.Tags{"table": "orders", "error": "true"})
+	rec.AssertCalled(t, "batch.process", metrics.Tags{"size": "10"})
+	rec.AssertCalled(t, "func.timing", metrics.Tags{"func_name": "closure"})
+}
+
+func TestStuckSpanEndedOnce(t *testing.T) {
+	require.NoError(t, metrics.Init("", "", &metrics.StatterConfig{MockingEnabled: true, StuckFunctionTimeout: time.Second}))
+	rec := metricstest.New(t)
+	spans := metricstest.RecordSpans(t)
+
+	stop := metrics.TimingCtxWithErr(context.Background(), "db.query", "table", "orders")
+	time.Sleep(1200 * time.Millisecond)
+	err := errors.New("deadlock")
+	stop(&err)
+
+	rec.AssertCount(t, "db.query.stuck", 1)
+	require.Len(t, spans.Named("db.query"), 1)
+	spans.AssertStatus(t, "db.query", codes.Error)
+	assert.Equal(t, "stuck", spans.Named("db.query")[0].StatusDescription)
+}
@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StdoutFormatText = "text"
+	StdoutFormatJSON = "json"
+)
+
+// stdoutWriter buffers output lines of the stdout agent and flushes them periodically.
+type stdoutWriter struct {
+	path          string
+	flushInterval time.Duration
+
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer // nil for stdout
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newStdoutWriter writes to stdout if path is empty or "-", and appends to the file at path otherwise.
+func newStdoutWriter(path string, flushInterval time.Duration) (*stdoutWriter, error) {
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+
+	w := &stdoutWriter{
+		path:          path,
+		flushInterval: flushInterval,
+		w:             bufio.NewWriter(os.Stdout),
+		done:          make(chan struct{}),
+	}
+	if path != "" && path != "-" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w.w = bufio.NewWriter(f)
+		w.closer = f
+	}
+
+	go w.flushLoop()
+	return w, nil
+}
+
+func (w *stdoutWriter) writeLine(line string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, _ = w.w.WriteString(line)
+	_ = w.w.WriteByte('\n')
+}
+
+func (w *stdoutWriter) flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Flush()
+}
+
+func (w *stdoutWriter) flushLoop() {
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			_ = w.flush()
+		}
+	}
+}
+
+func (w *stdoutWriter) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		err = w.flush()
+		if w.closer != nil {
+			if closeErr := w.closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+// metricPoint is a single Statter call as written by the stdout agent.
+type metricPoint struct {
+	Time  time.Time         `json:"time"`
+	Kind  string            `json:"kind"` // count, gauge, timing or histogram
+	Name  string            `json:"name"`
+	Value float64           `json:"value"`
+	Unit  string            `json:"unit,omitempty"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+// stdoutStatter writes human-readable or JSON-lines metric points for local runs, see StdoutAgent.
+type stdoutStatter struct {
+	out      *stdoutWriter
+	prefix   string
+	format   string
+	baseTags []string
+}
+
+func newStdoutStatter(path, prefix, format string, flushInterval time.Duration, baseTags []string) (*stdoutStatter, error) {
+	out, err := newStdoutWriter(path, flushInterval)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		format = StdoutFormatText
+	}
+	return &stdoutStatter{
+		out:      out,
+		prefix:   prefix,
+		format:   format,
+		baseTags: baseTags,
+	}, nil
+}
+
+func (s *stdoutStatter) write(kind, name string, value float64, unit string, tags []string) {
+	p := metricPoint{
+		Time:  time.Now(),
+		Kind:  kind,
+		Name:  s.prefix + name,
+		Value: value,
+		Unit:  unit,
+		Tags:  parseTagPairs(s.baseTags, tags),
+	}
+
+	if s.format == StdoutFormatJSON {
+		line, err := json.Marshal(p)
+		if err != nil {
+			return
+		}
+		s.out.writeLine(string(line))
+		return
+	}
+	s.out.writeLine(fmt.Sprintf("%s %-9s %s %s%s %s",
+		p.Time.Format("15:04:05.000"), p.Kind, p.Name,
+		strconv.FormatFloat(p.Value, 'f', -1, 64), p.Unit, formatTextTags(p.Tags),
+	))
+}
+
+func (s *stdoutStatter) Count(name string, value int64, tags []string, rate float64) error {
+	s.write("count", name, float64(value), "", tags)
+	return nil
+}
+
+func (s *stdoutStatter) Incr(name string, tags []string, rate float64) error {
+	s.write("count", name, 1, "", tags)
+	return nil
+}
+
+func (s *stdoutStatter) Decr(name string, tags []string, rate float64) error {
+	s.write("count", name, -1, "", tags)
+	return nil
+}
+
+func (s *stdoutStatter) Gauge(name string, value float64, tags []string, rate float64) error {
+	s.write("gauge", name, value, "", tags)
+	return nil
+}
+
+func (s *stdoutStatter) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	s.write("timing", name, float64(value)/float64(time.Millisecond), "ms", tags)
+	return nil
+}
+
+func (s *stdoutStatter) Histogram(name string, value float64, tags []string, rate float64) error {
+	s.write("histogram", name, value, "", tags)
+	return nil
+}
+
+func (s *stdoutStatter) Close() error {
+	return s.out.Close()
+}
+
+// parseTagPairs converts "key=value" tags into a map, later tags override earlier ones.
+func parseTagPairs(tagLists ...[]string) map[string]string {
+	res := make(map[string]string)
+	for _, tags := range tagLists {
+		for _, tag := range tags {
+			if idx := strings.IndexByte(tag, '='); idx > 0 {
+				res[tag[:idx]] = tag[idx+1:]
+			}
+		}
+	}
+	return res
+}
+
+// formatTextTags returns tags as space-separated key=value pairs sorted by key.
+func formatTextTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
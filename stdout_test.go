@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stdoutOuterFunc(ctx context.Context) {
+	ctx, stop := ReportFuncTimingCtx(ctx, Tags{"market": "INJ/USDT"})
+	defer stop()
+	stdoutInnerFunc(ctx)
+}
+
+func stdoutInnerFunc(ctx context.Context) {
+	_, stop := ReportFuncTimingCtx(ctx)
+	defer stop()
+}
+
+func initStdoutAgent(t *testing.T, format string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "metrics.out")
+	restoreStatter := SetStatter(nil)
+	restoreTracer := SetTracer(nil)
+	t.Cleanup(func() {
+		restoreStatter()
+		restoreTracer()
+	})
+
+	err := Init(path, "svc.", &StatterConfig{
+		Agent:          StdoutAgent,
+		EnvName:        "test",
+		TracingEnabled: true,
+		StdoutFormat:   format,
+	})
+	require.NoError(t, err)
+	return path
+}
+
+func TestStdoutAgentJSON(t *testing.T) {
+	path := initStdoutAgent(t, StdoutFormatJSON)
+
+	Counter("orders", 3, "market", "INJ/USDT")
+	stdoutOuterFunc(context.Background())
+	Close()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var (
+		points []metricPoint
+		spans  []spanJSON
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		if _, ok := line["trace_id"]; ok {
+			var s spanJSON
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &s))
+			spans = append(spans, s)
+		} else {
+			var p metricPoint
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &p))
+			points = append(points, p)
+		}
+	}
+
+	require.Len(t, points, 3)
+	assert.Equal(t, "count", points[0].Kind)
+	assert.Equal(t, "svc.orders", points[0].Name)
+	assert.EqualValues(t, 3, points[0].Value)
+	assert.Equal(t, map[string]string{"env": "test", "market": "INJ/USDT"}, points[0].Tags)
+	assert.Equal(t, "timing", points[1].Kind)
+	assert.Equal(t, "ms", points[1].Unit)
+	assert.Equal(t, "stdoutInnerFunc", points[1].Tags["func_name"])
+
+	require.Len(t, spans, 2)
+	assert.Equal(t, "stdoutOuterFunc", spans[0].Name)
+	assert.Equal(t, 0, spans[0].Depth)
+	assert.Equal(t, "INJ/USDT", spans[0].Attributes["market"])
+	assert.Equal(t, "stdoutInnerFunc", spans[1].Name)
+	assert.Equal(t, 1, spans[1].Depth)
+	assert.Equal(t, spans[0].SpanID, spans[1].ParentSpanID)
+}
+
+func TestStdoutAgentText(t *testing.T) {
+	path := initStdoutAgent(t, "")
+
+	Gauge("queue.size", 7.5, "queue", "default")
+	stdoutOuterFunc(context.Background())
+	Close()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 6)
+	assert.Regexp(t, `^\d\d:\d\d:\d\d\.\d{3} gauge     svc.queue.size 7.5 env=test queue=default$`, lines[0])
+	assert.Regexp(t, `timing    svc.func.timing [\d.]+ms env=test func_name=stdoutInnerFunc$`, lines[1])
+	assert.Regexp(t, `^trace [0-9a-f]{32}$`, lines[3])
+	assert.Regexp(t, `^  stdoutOuterFunc \S+ market=INJ/USDT$`, lines[4])
+	assert.Regexp(t, `^    stdoutInnerFunc \S+$`, lines[5])
+}
@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const stdoutMaxPendingTraces = 1000
+
+// stdoutSpanExporter writes finished traces as indented span trees, or as JSON lines with the span depth.
+// Spans are held back until the local root span of their trace is exported.
+type stdoutSpanExporter struct {
+	out      *stdoutWriter
+	format   string
+	ownsOut  bool // whether out is closed on shutdown, it's shared with the stdout statter otherwise
+	mu       sync.Mutex
+	pending  map[trace.TraceID][]sdktrace.ReadOnlySpan
+	shutdown bool
+}
+
+func newStdoutSpanExporter(out *stdoutWriter, format string, ownsOut bool) *stdoutSpanExporter {
+	if format == "" {
+		format = StdoutFormatText
+	}
+	return &stdoutSpanExporter{
+		out:     out,
+		format:  format,
+		ownsOut: ownsOut,
+		pending: make(map[trace.TraceID][]sdktrace.ReadOnlySpan),
+	}
+}
+
+// spanJSON is a single span as written in the JSON format.
+type spanJSON struct {
+	TraceID           string            `json:"trace_id"`
+	SpanID            string            `json:"span_id"`
+	ParentSpanID      string            `json:"parent_span_id,omitempty"`
+	Name              string            `json:"name"`
+	Depth             int               `json:"depth"`
+	Start             time.Time         `json:"start"`
+	Duration          time.Duration     `json:"duration_ns"`
+	Status            string            `json:"status,omitempty"`
+	StatusDescription string            `json:"status_description,omitempty"`
+	Attributes        map[string]string `json:"attributes,omitempty"`
+}
+
+func (e *stdoutSpanExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.shutdown {
+		return nil
+	}
+
+	for _, s := range spans {
+		id := s.SpanContext().TraceID()
+		e.pending[id] = append(e.pending[id], s)
+		if !s.Parent().IsValid() || s.Parent().IsRemote() {
+			e.writeTrace(e.pending[id])
+			delete(e.pending, id)
+		}
+	}
+
+	// roots of these traces may never end, write what we have rather than growing
+	if len(e.pending) > stdoutMaxPendingTraces {
+		e.writePending()
+	}
+	return nil
+}
+
+func (e *stdoutSpanExporter) Shutdown(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.shutdown {
+		return nil
+	}
+	e.shutdown = true
+	e.writePending()
+
+	if e.ownsOut {
+		return e.out.Close()
+	}
+	return e.out.flush()
+}
+
+func (e *stdoutSpanExporter) writePending() {
+	for id, spans := range e.pending {
+		e.writeTrace(spans)
+		delete(e.pending, id)
+	}
+}
+
+// writeTrace writes spans of a single trace depth-first, siblings ordered by start time.
+func (e *stdoutSpanExporter) writeTrace(spans []sdktrace.ReadOnlySpan) {
+	byID := make(map[trace.SpanID]bool, len(spans))
+	for _, s := range spans {
+		byID[s.SpanContext().SpanID()] = true
+	}
+	children := make(map[trace.SpanID][]sdktrace.ReadOnlySpan)
+	var roots []sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		if parent := s.Parent().SpanID(); s.Parent().IsValid() && byID[parent] {
+			children[parent] = append(children[parent], s)
+		} else {
+			roots = append(roots, s)
+		}
+	}
+
+	if e.format != StdoutFormatJSON {
+		e.out.writeLine("trace " + spans[0].SpanContext().TraceID().String())
+	}
+	var walk func(s sdktrace.ReadOnlySpan, depth int)
+	walk = func(s sdktrace.ReadOnlySpan, depth int) {
+		e.writeSpan(s, depth)
+		next := children[s.SpanContext().SpanID()]
+		sortByStart(next)
+		for _, child := range next {
+			walk(child, depth+1)
+		}
+	}
+	sortByStart(roots)
+	for _, root := range roots {
+		walk(root, 0)
+	}
+}
+
+func (e *stdoutSpanExporter) writeSpan(s sdktrace.ReadOnlySpan, depth int) {
+	attrs := make(map[string]string, len(s.Attributes()))
+	for _, attr := range s.Attributes() {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	d := s.EndTime().Sub(s.StartTime())
+
+	if e.format == StdoutFormatJSON {
+		js := spanJSON{
+			TraceID:           s.SpanContext().TraceID().String(),
+			SpanID:            s.SpanContext().SpanID().String(),
+			Name:              s.Name(),
+			Depth:             depth,
+			Start:             s.StartTime(),
+			Duration:          d,
+			StatusDescription: s.Status().Description,
+			Attributes:        attrs,
+		}
+		if s.Parent().IsValid() {
+			js.ParentSpanID = s.Parent().SpanID().String()
+		}
+		if s.Status().Code != codes.Unset {
+			js.Status = s.Status().Code.String()
+		}
+		line, err := json.Marshal(js)
+		if err != nil {
+			return
+		}
+		e.out.writeLine(string(line))
+		return
+	}
+
+	line := fmt.Sprintf("%s%s %v", strings.Repeat("  ", depth+1), s.Name(), d.Round(time.Microsecond))
+	if s.Status().Code == codes.Error {
+		line += fmt.Sprintf(" [error: %s]", s.Status().Description)
+	}
+	if len(attrs) > 0 {
+		line += " " + formatTextTags(attrs)
+	}
+	e.out.writeLine(line)
+}
+
+func sortByStart(spans []sdktrace.ReadOnlySpan) {
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].StartTime().Before(spans[j].StartTime())
+	})
+}
@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"container/list"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newSpanProcessor batches finished spans for exp, behind a tail sampler when TailSamplingEnabled.
+func newSpanProcessor(exp sdktrace.SpanExporter, cfg *StatterConfig, batchOpts ...sdktrace.BatchSpanProcessorOption) sdktrace.SpanProcessor {
+	batcher := sdktrace.NewBatchSpanProcessor(exp, batchOpts...)
+	if !cfg.TailSamplingEnabled {
+		return batcher
+	}
+	return newTailSamplingProcessor(batcher, cfg)
+}
+
+// tailSamplingProcessor buffers spans per trace until the local root span ends and then decides whether the trace
+// is exported. Traces with an error status, including spans of stuck functions, and traces with a span slower than
+// the latency threshold are always kept, the rest is sampled by ratio. Traces whose root doesn't end within maxAge,
+// that don't fit into maxTraces, or that buffered maxSpans spans, are decided early on the spans buffered so far.
+// Decisions of the last maxTraces traces are remembered, so spans ending after their trace was decided follow it,
+// unless a late span fails or is slow, which flips a dropped trace to kept from that span on.
+type tailSamplingProcessor struct {
+	next      sdktrace.SpanProcessor
+	ratio     float64
+	latency   time.Duration
+	maxTraces int
+	maxSpans  int
+	maxAge    time.Duration
+
+	mu        sync.Mutex
+	traces    map[trace.TraceID]*bufferedTrace
+	order     *list.List // of trace.TraceID, oldest first
+	decided   map[trace.TraceID]bool
+	decisions []trace.TraceID // ring of decided trace ids, the oldest decision is forgotten first
+	lastIdx   int
+	shutdown  bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type bufferedTrace struct {
+	spans     []sdktrace.ReadOnlySpan
+	keep      bool
+	firstSeen time.Time
+	elem      *list.Element
+}
+
+func newTailSamplingProcessor(next sdktrace.SpanProcessor, cfg *StatterConfig) *tailSamplingProcessor {
+	p := &tailSamplingProcessor{
+		next:      next,
+		ratio:     cfg.TailSampleRatio,
+		latency:   cfg.TailLatencyThreshold,
+		maxTraces: cfg.TailMaxTraces,
+		maxSpans:  cfg.TailMaxTraceSpans,
+		maxAge:    cfg.TailMaxTraceAge,
+		traces:    make(map[trace.TraceID]*bufferedTrace),
+		order:     list.New(),
+		decided:   make(map[trace.TraceID]bool),
+		stop:      make(chan struct{}),
+	}
+	if p.latency <= 0 {
+		p.latency = time.Second
+	}
+	if p.maxTraces <= 0 {
+		p.maxTraces = 10000
+	}
+	if p.maxSpans <= 0 {
+		p.maxSpans = 1000
+	}
+	if p.maxAge <= 0 {
+		// stuck functions end their spans only after StuckFunctionTimeout
+		p.maxAge = max(time.Minute, cfg.StuckFunctionTimeout)
+	}
+	p.decisions = make([]trace.TraceID, p.maxTraces)
+
+	go p.sweepLoop()
+	return p
+}
+
+func (p *tailSamplingProcessor) OnStart(ctx context.Context, s sdktrace.ReadWriteSpan) {
+	p.next.OnStart(ctx, s)
+}
+
+func (p *tailSamplingProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if !s.SpanContext().IsSampled() {
+		return
+	}
+	now := time.Now()
+
+	p.mu.Lock()
+	if p.shutdown {
+		p.mu.Unlock()
+		return
+	}
+	id := s.SpanContext().TraceID()
+	if keep, ok := p.decided[id]; ok {
+		if !keep && p.mustKeep(s) {
+			keep = true
+			p.decided[id] = true
+		}
+		p.mu.Unlock()
+		if keep {
+			p.next.OnEnd(s)
+		}
+		return
+	}
+
+	done := p.expire(now)
+	t, ok := p.traces[id]
+	if !ok {
+		t = &bufferedTrace{firstSeen: now}
+		t.elem = p.order.PushBack(id)
+		p.traces[id] = t
+	}
+	t.spans = append(t.spans, s)
+	if p.mustKeep(s) {
+		t.keep = true
+	}
+	if !s.Parent().IsValid() || s.Parent().IsRemote() || len(t.spans) >= p.maxSpans {
+		done = append(done, p.decide(id))
+	}
+	p.mu.Unlock()
+
+	p.export(done)
+}
+
+// mustKeep reports whether s makes its trace kept, i.e. it failed, including stuck spans, or it's slow.
+func (p *tailSamplingProcessor) mustKeep(s sdktrace.ReadOnlySpan) bool {
+	return s.Status().Code == codes.Error || s.EndTime().Sub(s.StartTime()) >= p.latency
+}
+
+// expire decides traces older than maxAge, and the oldest ones while the buffer is full.
+func (p *tailSamplingProcessor) expire(now time.Time) [][]sdktrace.ReadOnlySpan {
+	var done [][]sdktrace.ReadOnlySpan
+	for p.order.Len() > 0 {
+		id := p.order.Front().Value.(trace.TraceID)
+		if now.Sub(p.traces[id].firstSeen) < p.maxAge && len(p.traces) < p.maxTraces {
+			break
+		}
+		done = append(done, p.decide(id))
+	}
+	return done
+}
+
+// decide removes the trace from the buffer, remembers the decision and returns its spans if it's kept.
+func (p *tailSamplingProcessor) decide(id trace.TraceID) []sdktrace.ReadOnlySpan {
+	t := p.traces[id]
+	delete(p.traces, id)
+	p.order.Remove(t.elem)
+
+	keep := t.keep || rand.Float64() < p.ratio
+	if forgotten := p.decisions[p.lastIdx]; forgotten.IsValid() {
+		delete(p.decided, forgotten)
+	}
+	p.decisions[p.lastIdx] = id
+	p.lastIdx = (p.lastIdx + 1) % len(p.decisions)
+	p.decided[id] = keep
+
+	if keep {
+		return t.spans
+	}
+	return nil
+}
+
+func (p *tailSamplingProcessor) export(done [][]sdktrace.ReadOnlySpan) {
+	for _, spans := range done {
+		for _, span := range spans {
+			p.next.OnEnd(span)
+		}
+	}
+}
+
+// sweepLoop decides traces past maxAge even when no spans end, until the processor is shut down.
+func (p *tailSamplingProcessor) sweepLoop() {
+	ticker := time.NewTicker(p.maxAge / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			p.mu.Lock()
+			done := p.expire(now)
+			p.mu.Unlock()
+			p.export(done)
+		}
+	}
+}
+
+func (p *tailSamplingProcessor) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+
+	p.mu.Lock()
+	var done [][]sdktrace.ReadOnlySpan
+	for p.order.Len() > 0 {
+		done = append(done, p.decide(p.order.Front().Value.(trace.TraceID)))
+	}
+	p.shutdown = true
+	p.mu.Unlock()
+
+	p.export(done)
+	return p.next.Shutdown(ctx)
+}
+
+func (p *tailSamplingProcessor) ForceFlush(ctx context.Context) error {
+	return p.next.ForceFlush(ctx)
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTailSamplingProcessor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	processor := newTailSamplingProcessor(recorder, &StatterConfig{
+		TailLatencyThreshold: 50 * time.Millisecond,
+		TailMaxTraces:        2,
+	})
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor)).Tracer("test")
+
+	seen := 0
+	exported := func() map[string]int {
+		names := make(map[string]int)
+		ended := recorder.Ended()
+		for _, s := range ended[seen:] {
+			names[s.Name()]++
+		}
+		seen = len(ended)
+		return names
+	}
+
+	// fast traces without errors are dropped with the default ratio of 0
+	ctx, root := tracer.Start(context.Background(), "ok")
+	_, child := tracer.Start(ctx, "ok.child")
+	child.End()
+	root.End()
+	assert.Empty(t, exported())
+
+	// a failing child keeps the whole trace
+	ctx, root = tracer.Start(context.Background(), "failing")
+	_, child = tracer.Start(ctx, "failing.child")
+	child.SetStatus(codes.Error, "stuck")
+	child.End()
+	assert.Empty(t, exported(), "spans are buffered until the root ends")
+	root.End()
+	assert.Equal(t, map[string]int{"failing": 1, "failing.child": 1}, exported())
+
+	// slow traces are kept
+	start := time.Now()
+	_, root = tracer.Start(context.Background(), "slow", trace.WithTimestamp(start))
+	root.End(trace.WithTimestamp(start.Add(100 * time.Millisecond)))
+	assert.Equal(t, map[string]int{"slow": 1}, exported())
+
+	// traces that don't fit into the buffer are decided early
+	for _, name := range []string{"open.1", "open.2", "open.3"} {
+		ctx, root := tracer.Start(context.Background(), name)
+		_, child := tracer.Start(ctx, name+".child")
+		child.SetStatus(codes.Error, "failed")
+		child.End()
+		defer root.End()
+	}
+	assert.Equal(t, map[string]int{"open.1.child": 1}, exported())
+	assert.Len(t, processor.traces, 2)
+
+	assert.NoError(t, processor.Shutdown(context.Background()))
+	assert.Equal(t, map[string]int{"open.2.child": 1, "open.3.child": 1}, exported())
+}
+
+func TestTailSamplingProcessorEarlyDecisions(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	processor := newTailSamplingProcessor(recorder, &StatterConfig{
+		TailMaxTraceSpans: 2,
+		TailMaxTraceAge:   100 * time.Millisecond,
+	})
+	defer processor.Shutdown(context.Background())
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor)).Tracer("test")
+
+	names := func() []string {
+		var names []string
+		for _, s := range recorder.Ended() {
+			names = append(names, s.Name())
+		}
+		return names
+	}
+
+	// a trace reaching the span cap is decided, and spans ending later follow the decision
+	ctx, root := tracer.Start(context.Background(), "big")
+	for _, name := range []string{"big.1", "big.2", "big.3"} {
+		_, child := tracer.Start(ctx, name)
+		child.SetStatus(codes.Error, "failed")
+		child.End()
+	}
+	root.End()
+	assert.Equal(t, []string{"big.1", "big.2", "big.3", "big"}, names())
+
+	// traces whose root never ends are decided by the sweep, without other spans ending
+	ctx, root = tracer.Start(context.Background(), "abandoned")
+	defer root.End()
+	_, child := tracer.Start(ctx, "abandoned.child")
+	child.SetStatus(codes.Error, "failed")
+	child.End()
+	assert.Eventually(t, func() bool {
+		return len(names()) == 5
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "abandoned.child", names()[4])
+}
+
+func TestTailSamplingProcessorLateFailure(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	processor := newTailSamplingProcessor(recorder, &StatterConfig{
+		TailMaxTraceAge: 50 * time.Millisecond,
+	})
+	defer processor.Shutdown(context.Background())
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor)).Tracer("test")
+
+	ctx, root := tracer.Start(context.Background(), "stuck")
+	_, child := tracer.Start(ctx, "stuck.child")
+	child.End()
+
+	// the trace is swept as dropped while its root is still running
+	assert.Eventually(t, func() bool {
+		processor.mu.Lock()
+		defer processor.mu.Unlock()
+		return len(processor.traces) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, recorder.Ended())
+
+	// the stuck root flips the decision, later spans of the trace are kept as well
+	root.SetStatus(codes.Error, "stuck")
+	root.End()
+	_, late := tracer.Start(ctx, "stuck.late")
+	late.End()
+
+	var names []string
+	for _, s := range recorder.Ended() {
+		names = append(names, s.Name())
+	}
+	assert.Equal(t, []string{"stuck", "stuck.late"}, names)
+}
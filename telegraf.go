@@ -1,19 +1,60 @@
 package metrics
 
 import (
+	"math/rand"
 	"strings"
+	"sync"
 	"time"
 
 	statsd "github.com/alexcesaro/statsd"
 )
 
+const (
+	unixAddrPrefix       = "unix://"
+	unixgramAddrPrefix   = "unixgram://"
+	unixStreamAddrPrefix = "unixstream://"
+)
+
+// telegrafNetworkAddr splits a Telegraf agent address into network and address for net.Dial.
+func telegrafNetworkAddr(addr string) (network, address string, err error) {
+	switch {
+	case strings.HasPrefix(addr, unixStreamAddrPrefix):
+		// the statsd client trims the newline ending each flush, so lines would run together on a stream,
+		// see StatterConfig.Addr
+		return "", "", ErrUnsupportedAddr
+	case strings.HasPrefix(addr, unixAddrPrefix):
+		return "unixgram", strings.TrimPrefix(addr, unixAddrPrefix), nil
+	case strings.HasPrefix(addr, unixgramAddrPrefix):
+		return "unixgram", strings.TrimPrefix(addr, unixgramAddrPrefix), nil
+	default:
+		return "udp", addr, nil
+	}
+}
+
+// telegrafBucket appends InfluxDB-style tags to the bucket, base tags are appended by the statsd client.
+func telegrafBucket(bucket string, tags []string) string {
+	if len(tags) == 0 {
+		return bucket
+	}
+	return bucket + "," + strings.Join(tags, ",")
+}
+
 type telegrafStatter struct {
 	client *statsd.Client
 	Statter
+
+	sampled sync.Map // float64 sample rate -> *statsd.Client clone of client, read on every sampled call
 }
 
-// telegrafStatter is wrapper of telegraf, implementing the statter
-func newTelegrafStatter(opts ...statsd.Option) (Statter, error) {
+// telegrafStatter is wrapper of telegraf, implementing the statter.
+// addr is host:port for UDP, or unix:///path/to.sock or unixgram:///path/to.sock for a unix datagram socket.
+func newTelegrafStatter(addr string, opts ...statsd.Option) (Statter, error) {
+	network, address, err := telegrafNetworkAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, statsd.Network(network), statsd.Address(address))
+
 	c, err := statsd.New(opts...)
 	if err != nil {
 		return nil, err
@@ -24,39 +65,55 @@ func newTelegrafStatter(opts ...statsd.Option) (Statter, error) {
 	return statter, nil
 }
 
+// rated returns the client sampling at rate, which drops calls and annotates the sent ones with @rate.
+func (t *telegrafStatter) rated(rate float64) *statsd.Client {
+	if rate <= 0 || rate >= 1 {
+		return t.client
+	}
+	if c, ok := t.sampled.Load(rate); ok {
+		return c.(*statsd.Client)
+	}
+	c, _ := t.sampled.LoadOrStore(rate, t.client.Clone(statsd.SampleRate(float32(rate))))
+	return c.(*statsd.Client)
+}
+
 func (t *telegrafStatter) Count(bucket string, value int64, tags []string, rate float64) (err error) {
-	s := bucket + "," + strings.Join(tags, ",")
-	t.client.Count(s, value)
+	s := telegrafBucket(bucket, tags)
+	t.rated(rate).Count(s, value)
 	return nil
 }
 
 func (t *telegrafStatter) Incr(bucket string, tags []string, rate float64) error {
-	s := bucket + "," + strings.Join(tags, ",")
-	t.client.Increment(s)
+	s := telegrafBucket(bucket, tags)
+	t.rated(rate).Increment(s)
 	return nil
 }
 
 func (t *telegrafStatter) Decr(bucket string, tags []string, rate float64) error {
-	s := bucket + "," + strings.Join(tags, ",")
-	t.client.Count(s, -1)
+	s := telegrafBucket(bucket, tags)
+	t.rated(rate).Count(s, -1)
 	return nil
 }
 
 func (t *telegrafStatter) Gauge(bucket string, value float64, tags []string, rate float64) error {
-	s := bucket + "," + strings.Join(tags, ",")
+	// the statsd client doesn't sample gauges, so they're dropped here
+	if rate > 0 && rate < 1 && rand.Float64() >= rate {
+		return nil
+	}
+	s := telegrafBucket(bucket, tags)
 	t.client.Gauge(s, value)
 	return nil
 }
 
 func (t *telegrafStatter) Timing(bucket string, value time.Duration, tags []string, rate float64) error {
-	s := bucket + "," + strings.Join(tags, ",")
-	t.client.Timing(s, int(value/time.Millisecond))
+	s := telegrafBucket(bucket, tags)
+	t.rated(rate).Timing(s, int(value/time.Millisecond))
 	return nil
 }
 
 func (t *telegrafStatter) Histogram(bucket string, value float64, tags []string, rate float64) error {
-	s := bucket + "," + strings.Join(tags, ",")
-	t.client.Histogram(s, value)
+	s := telegrafBucket(bucket, tags)
+	t.rated(rate).Histogram(s, value)
 	return nil
 }
 
@@ -0,0 +1,332 @@
+package metrics
+
+import (
+	"math"
+	"math/rand"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	telegrafDefaultMaxPacketSize = 1432
+	telegrafDefaultReservoirSize = 256
+	telegrafDefaultFlushInterval = 10 * time.Second
+	telegrafDialTimeout          = 5 * time.Second
+	telegrafBucketStackTags      = 16
+)
+
+// aggregatingTelegrafStatter aggregates calls in memory and sends them to Telegraf once per flush interval,
+// see TelegrafAggregationEnabled. Counts are summed and the last gauge value is kept per bucket, timings and
+// histograms are sampled into a bounded reservoir per bucket and sent with the sample rate, so Telegraf still
+// counts every call, including the ones dropped by SampleRates before they got here.
+type aggregatingTelegrafStatter struct {
+	out           *statsdPacketWriter
+	prefix        string
+	baseTags      string // ",k=v" pairs appended to every bucket
+	reservoirSize int
+
+	mu         sync.Mutex
+	counters   map[string]int64
+	gauges     map[string]float64
+	timings    map[string]*reservoir
+	histograms map[string]*reservoir
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// newAggregatingTelegrafStatter connects lazily, so a Telegraf sidecar whose socket doesn't exist yet doesn't fail Init,
+// and reconnects on write errors.
+func newAggregatingTelegrafStatter(addr, prefix string, cfg *StatterConfig, baseTags []string) (Statter, error) {
+	network, address, err := telegrafNetworkAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var base strings.Builder
+	for i := 0; i+1 < len(baseTags); i += 2 {
+		base.WriteString("," + baseTags[i] + "=" + baseTags[i+1])
+	}
+
+	s := &aggregatingTelegrafStatter{
+		out:           newStatsdPacketWriter(network, address, cfg.TelegrafMaxPacketSize),
+		prefix:        prefix,
+		baseTags:      base.String(),
+		reservoirSize: cfg.TelegrafTimingReservoirSize,
+		counters:      make(map[string]int64),
+		gauges:        make(map[string]float64),
+		timings:       make(map[string]*reservoir),
+		histograms:    make(map[string]*reservoir),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	if s.reservoirSize <= 0 {
+		s.reservoirSize = telegrafDefaultReservoirSize
+	}
+	interval := cfg.TelegrafFlushInterval
+	if interval <= 0 {
+		interval = telegrafDefaultFlushInterval
+	}
+
+	go s.flushLoop(interval)
+	return s, nil
+}
+
+// bucket returns the aggregation key, tags are sorted since they come from maps in random order.
+// Up to telegrafBucketStackTags tags are sorted on the stack, so the key is the only allocation.
+func (s *aggregatingTelegrafStatter) bucket(name string, tags []string) string {
+	if !slices.IsSorted(tags) {
+		var buf [telegrafBucketStackTags]string
+		sorted := append(buf[:0], tags...)
+		slices.Sort(sorted)
+		tags = sorted
+	}
+
+	size := len(s.prefix) + len(name) + len(s.baseTags)
+	for _, tag := range tags {
+		size += 1 + len(tag)
+	}
+	var b strings.Builder
+	b.Grow(size)
+	b.WriteString(s.prefix)
+	b.WriteString(name)
+	for _, tag := range tags {
+		b.WriteByte(',')
+		b.WriteString(tag)
+	}
+	b.WriteString(s.baseTags)
+	return b.String()
+}
+
+func (s *aggregatingTelegrafStatter) count(name string, value int64, tags []string) {
+	bucket := s.bucket(name, tags)
+	s.mu.Lock()
+	s.counters[bucket] += value
+	s.mu.Unlock()
+}
+
+func (s *aggregatingTelegrafStatter) sample(histogram bool, name string, value float64, tags []string, rate float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	bucket := s.bucket(name, tags)
+	s.mu.Lock()
+	samples := s.timings
+	if histogram {
+		samples = s.histograms
+	}
+	r, ok := samples[bucket]
+	if !ok {
+		r = &reservoir{values: make([]float64, 0, s.reservoirSize)}
+		samples[bucket] = r
+	}
+	r.add(value, rate)
+	s.mu.Unlock()
+}
+
+func (s *aggregatingTelegrafStatter) Count(name string, value int64, tags []string, rate float64) error {
+	s.count(name, value, tags)
+	return nil
+}
+
+func (s *aggregatingTelegrafStatter) Incr(name string, tags []string, rate float64) error {
+	s.count(name, 1, tags)
+	return nil
+}
+
+func (s *aggregatingTelegrafStatter) Decr(name string, tags []string, rate float64) error {
+	s.count(name, -1, tags)
+	return nil
+}
+
+func (s *aggregatingTelegrafStatter) Gauge(name string, value float64, tags []string, rate float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	bucket := s.bucket(name, tags)
+	s.mu.Lock()
+	s.gauges[bucket] = value
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *aggregatingTelegrafStatter) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	s.sample(false, name, float64(value)/float64(time.Millisecond), tags, rate)
+	return nil
+}
+
+func (s *aggregatingTelegrafStatter) Histogram(name string, value float64, tags []string, rate float64) error {
+	s.sample(true, name, value, tags, rate)
+	return nil
+}
+
+func (s *aggregatingTelegrafStatter) flushLoop(interval time.Duration) {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			s.flush()
+			return
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+func (s *aggregatingTelegrafStatter) flush() {
+	s.mu.Lock()
+	counters, gauges, timings, histograms := s.counters, s.gauges, s.timings, s.histograms
+	s.counters = make(map[string]int64, len(counters))
+	s.gauges = make(map[string]float64, len(gauges))
+	s.timings = make(map[string]*reservoir, len(timings))
+	s.histograms = make(map[string]*reservoir, len(histograms))
+	s.mu.Unlock()
+
+	for bucket, v := range counters {
+		s.out.writeLine(bucket + ":" + strconv.FormatInt(v, 10) + "|c")
+	}
+	for bucket, v := range gauges {
+		if v < 0 {
+			// a signed gauge is a change of the current value in statsd, so reset it first
+			s.out.writeLine(bucket + ":0|g")
+		}
+		s.out.writeLine(bucket + ":" + strconv.FormatFloat(v, 'f', -1, 64) + "|g")
+	}
+	for statType, samples := range map[string]map[string]*reservoir{"ms": timings, "h": histograms} {
+		for bucket, r := range samples {
+			suffix := "|" + statType
+			if rate := float64(len(r.values)) / r.total; rate < 1 {
+				suffix += "|@" + strconv.FormatFloat(rate, 'f', -1, 64)
+			}
+			for _, v := range r.values {
+				s.out.writeLine(bucket + ":" + strconv.FormatFloat(v, 'f', -1, 64) + suffix)
+			}
+		}
+	}
+
+	if err := s.out.flush(); err != nil {
+		errHandler(err)
+	}
+}
+
+// Close sends aggregated points and closes the connection.
+func (s *aggregatingTelegrafStatter) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		<-s.stopped
+		err = s.out.Close()
+	})
+	return err
+}
+
+// reservoir keeps a uniform sample of at most cap(values) of the values added, see Algorithm R.
+type reservoir struct {
+	values []float64
+	seen   int64   // values added
+	total  float64 // calls the added values stand for, values sampled at rate count as 1/rate calls
+}
+
+func (r *reservoir) add(v, rate float64) {
+	if rate <= 0 || rate >= 1 {
+		rate = 1
+	}
+	r.seen++
+	r.total += 1 / rate
+	if len(r.values) < cap(r.values) {
+		r.values = append(r.values, v)
+		return
+	}
+	if i := rand.Int63n(r.seen); i < int64(len(r.values)) {
+		r.values[i] = v
+	}
+}
+
+// statsdPacketWriter packs newline-separated statsd lines into datagrams of at most maxSize bytes,
+// a line longer than maxSize is sent on its own. It isn't safe for concurrent use.
+type statsdPacketWriter struct {
+	network string
+	address string
+	conn    net.Conn // dialed on the first send and after write errors
+	maxSize int
+	buf     []byte
+	err     error // first write error since the last flush
+}
+
+func newStatsdPacketWriter(network, address string, maxSize int) *statsdPacketWriter {
+	if maxSize <= 0 {
+		maxSize = telegrafDefaultMaxPacketSize
+	}
+	return &statsdPacketWriter{
+		network: network,
+		address: address,
+		maxSize: maxSize,
+		buf:     make([]byte, 0, maxSize),
+	}
+}
+
+func (w *statsdPacketWriter) writeLine(line string) {
+	if len(w.buf) > 0 && len(w.buf)+1+len(line) > w.maxSize {
+		w.send()
+	}
+	if len(w.buf) > 0 {
+		w.buf = append(w.buf, '\n')
+	}
+	w.buf = append(w.buf, line...)
+}
+
+func (w *statsdPacketWriter) send() {
+	if len(w.buf) == 0 {
+		return
+	}
+	if err := w.write(w.buf); err != nil && w.err == nil {
+		w.err = err
+	}
+	w.buf = w.buf[:0]
+}
+
+// write sends a datagram, dialing if there's no connection and redialing once if the write fails.
+func (w *statsdPacketWriter) write(packet []byte) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			if w.conn, err = net.DialTimeout(w.network, w.address, telegrafDialTimeout); err != nil {
+				w.conn = nil
+				return err
+			}
+		}
+		if _, err = w.conn.Write(packet); err == nil {
+			return nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
+
+// Close closes the connection, if any.
+func (w *statsdPacketWriter) Close() error {
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// flush sends buffered lines and returns the first write error since the previous flush.
+func (w *statsdPacketWriter) flush() error {
+	w.send()
+	err := w.err
+	w.err = nil
+	return err
+}
@@ -0,0 +1,124 @@
+package metrics_test
+
+import (
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/InjectiveLabs/metrics"
+	"github.com/InjectiveLabs/metrics/metricstest"
+)
+
+func TestTelegrafAggregation(t *testing.T) {
+	listener := metricstest.NewUDPListener(t)
+
+	err := metrics.Init(listener.Addr, "svc.", &metrics.StatterConfig{
+		Agent:                       metrics.TelegrafAgent,
+		EnvName:                     "test",
+		TelegrafAggregationEnabled:  true,
+		TelegrafFlushInterval:       time.Hour,
+		TelegrafTimingReservoirSize: 10,
+		TelegrafMaxPacketSize:       200,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		metrics.SetStatter(nil)
+	})
+
+	for i := 0; i < 1000; i++ {
+		metrics.Incr("orders.filled", "market", "INJ/USDT", "side", "buy")
+		metrics.Timer("db.query", time.Duration(i)*time.Millisecond, metrics.Tags{"table": "orders"})
+	}
+	metrics.Counter("orders", 3)
+	metrics.Counter("orders", 4)
+	metrics.Gauge("queue.size", 1, "queue", "default")
+	metrics.Gauge("queue.size", -2, "queue", "default")
+
+	// closing the statter sends what was aggregated
+	metrics.Close()
+
+	lines := listener.WaitForLines(t, 1+1+2+10, 5*time.Second)
+	assert.Contains(t, lines, "svc.orders.filled,market=INJ/USDT,side=buy,env=test:1000|c")
+	assert.Contains(t, lines, "svc.orders,env=test:7|c")
+	assert.Contains(t, lines, "svc.queue.size,queue=default,env=test:0|g")
+	assert.Contains(t, lines, "svc.queue.size,queue=default,env=test:-2|g")
+
+	var timings int
+	for _, line := range lines {
+		if strings.HasPrefix(line, "svc.db.query,table=orders,env=test:") {
+			assert.True(t, strings.HasSuffix(line, "|ms|@0.01"), line)
+			timings++
+		}
+	}
+	assert.Equal(t, 10, timings)
+
+	for _, packet := range listener.Packets() {
+		assert.LessOrEqual(t, len(packet), 200)
+	}
+	assert.Greater(t, len(listener.Packets()), 1)
+}
+
+func TestTelegrafAggregationSampleRates(t *testing.T) {
+	listener := metricstest.NewUDPListener(t)
+
+	err := metrics.Init(listener.Addr, "svc.", &metrics.StatterConfig{
+		Agent:                       metrics.TelegrafAgent,
+		EnvName:                     "test",
+		TelegrafAggregationEnabled:  true,
+		TelegrafFlushInterval:       time.Hour,
+		TelegrafTimingReservoirSize: 10,
+		SampleRates:                 map[string]float64{"db.query": 0.5},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		metrics.SetStatter(nil)
+	})
+
+	for i := 0; i < 1000; i++ {
+		metrics.Timer("db.query", time.Millisecond)
+	}
+	metrics.Close()
+
+	lines := listener.WaitForLines(t, 10, 5*time.Second)
+	_, rate, ok := strings.Cut(lines[0], "|@")
+	require.True(t, ok, lines[0])
+	r, err := strconv.ParseFloat(rate, 64)
+	require.NoError(t, err)
+	// calls dropped by the sample rate still count, so Telegraf scales the 10 sent values up to all calls
+	assert.InDelta(t, 1000, 10/r, 150)
+}
+
+func TestTelegrafAggregationLazyDial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telegraf.sock")
+
+	// the sidecar socket doesn't exist yet when the service starts
+	err := metrics.Init("unixgram://"+path, "svc.", &metrics.StatterConfig{
+		Agent:                      metrics.TelegrafAgent,
+		EnvName:                    "test",
+		TelegrafAggregationEnabled: true,
+		TelegrafFlushInterval:      time.Hour,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		metrics.SetStatter(nil)
+	})
+
+	conn, err := net.ListenPacket("unixgram", path)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	metrics.Counter("orders", 3)
+	metrics.Close()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 64*1024)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "svc.orders,env=test:3|c", string(buf[:n]))
+}
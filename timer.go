@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// timerStopFunc reports the timing of a running timer, a non-nil err marks its span as failed.
+type timerStopFunc func(err error, stopTags ...Tags)
+
+// startFuncTimer starts a timer reported as func.timing with the func_name tag, see startTimer.
+func startFuncTimer(ctx context.Context, fn string, tags ...Tags) (context.Context, timerStopFunc) {
+	return startTimer(ctx, fn, "func.timing", "func_name", Tags{"func_name": fn}, MergeTags(nil, tags...))
+}
+
+// startMetricTimer starts a timer reported under its own metric name, see startTimer.
+func startMetricTimer(ctx context.Context, metric string, tags Tags) (context.Context, timerStopFunc) {
+	return startTimer(ctx, metric, metric, "metric", nil, tags)
+}
+
+// startTimer is the implementation behind all timing helpers. When the returned function is called, it reports
+// the elapsed time as metric. While the timer runs, it has a child span of ctx named name and a runtime/trace region,
+// if tracing or the trace recorder are enabled. Timers still running after StuckFunctionTimeout are counted
+// as <metric>.stuck, with the .timing suffix trimmed, so function timers report func.stuck.
+// baseTags are added to the metric tags but not to the span.
+func startTimer(ctx context.Context, name, metric, regionTag string, baseTags, tags Tags) (context.Context, timerStopFunc) {
+	clientMux.RLock()
+	defer clientMux.RUnlock()
+
+	if client == nil {
+		return ctx, func(error, ...Tags) {}
+	}
+	t := time.Now()
+
+	var (
+		span       trace.Span
+		spanCtx    = ctx
+		stopRegion func() (func() error, error)
+	)
+	if tracer != nil {
+		spanCtx, span = tracer.Start(ctx, name)
+		for k, v := range tags {
+			span.SetAttributes(tagAttribute(k, v))
+		}
+	}
+	if traceRecorder != nil {
+		spanCtx, stopRegion = traceRecorder.startRegion(spanCtx, regionTag, name)
+	}
+	allTags := MergeTags(tags, baseTags)
+	spanCtx = withContextTags(spanCtx, allTags)
+	tagArray := JoinTags(allTags)
+
+	// the span is ended by whichever comes first, the stop func or the stuck detector
+	var spanEnded atomic.Bool
+
+	doneC := make(chan struct{})
+	go func(start time.Time, stuckTimeout time.Duration) {
+		timeout := time.NewTimer(stuckTimeout)
+		defer timeout.Stop()
+
+		select {
+		case <-doneC:
+			return
+		case <-timeout.C:
+			clientMux.RLock()
+			defer clientMux.RUnlock()
+
+			contextLogger(spanCtx).Warningf("detected stuck function: %s stuck for %v", name, time.Since(start))
+			if client != nil {
+				client.Incr(strings.TrimSuffix(metric, ".timing")+".stuck", tagArray, 1)
+			}
+			localProfiler.capture(spanCtx, name, time.Since(start))
+			if span != nil && spanEnded.CompareAndSwap(false, true) {
+				span.SetStatus(codes.Error, "stuck")
+				span.End()
+			}
+		}
+	}(t, config.StuckFunctionTimeout)
+
+	return spanCtx, func(err error, stopTags ...Tags) {
+		d := time.Since(t)
+		close(doneC)
+
+		clientMux.RLock()
+		if client != nil {
+			client.Timing(metric, d, JoinTags(MergeTags(MergeTags(tags, stopTags...), baseTags)), 1)
+		}
+		localProfiler.observe(spanCtx, name, d)
+		clientMux.RUnlock()
+
+		if span != nil && spanEnded.CompareAndSwap(false, true) {
+			for _, tags := range stopTags {
+				for k, v := range tags {
+					span.SetAttributes(tagAttribute(k, v))
+				}
+			}
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+		}
+		if stopRegion == nil {
+			return
+		}
+		// the buffer is captured right away, while the analysis and the sink write, up to an HTTP upload,
+		// run in the background, they report through the client so the lock must not be held here
+		write, err := stopRegion()
+		if err == nil && write != nil {
+			go func() {
+				if err := write(); err != nil {
+					contextLogger(spanCtx).WithError(err).Warningln("failed to write trace region snapshot", name)
+				}
+			}()
+			return
+		}
+		if err != nil {
+			contextLogger(spanCtx).WithError(err).Warningln("failed to snapshot trace region", name)
+		}
+	}
+}
@@ -1,19 +1,56 @@
 package metrics
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"os"
 	rtrace "runtime/trace"
+	"sync/atomic"
 	"time"
 
+	oteltrace "go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/trace"
 )
 
+// regionSnapshotCooldown is the default minimum time between two snapshots of slow regions of a recorder.
+const regionSnapshotCooldown = time.Minute
+
 type TraceRecorder struct {
 	*trace.FlightRecorder
 
 	snapshotThreshold time.Duration
+	sink              SnapshotSink
+	analyze           bool
+	regionSnapshots   *snapshotLimiter
+}
+
+// snapshotLimiter lets a single snapshot of slow regions be in flight, and at most one start per cooldown.
+// Regions ending while it's busy or cooling down are skipped rather than queued, so a latency spike
+// doesn't turn into a storm of buffer copies and uploads.
+type snapshotLimiter struct {
+	cooldown time.Duration
+	inFlight atomic.Bool
+	last     atomic.Int64 // unix nano of the last snapshot start
+}
+
+func (l *snapshotLimiter) acquire(now time.Time) bool {
+	if l == nil {
+		return true
+	}
+	if last := l.last.Load(); last != 0 && now.UnixNano()-last < int64(l.cooldown) {
+		return false
+	}
+	if !l.inFlight.CompareAndSwap(false, true) {
+		return false
+	}
+	l.last.Store(now.UnixNano())
+	return true
+}
+
+func (l *snapshotLimiter) release() {
+	if l != nil {
+		l.inFlight.Store(false)
+	}
 }
 
 // NewTraceRecorder creates new trace flight recorder that will continuously record latest execution trace in a circullar buffer
@@ -22,6 +59,8 @@ func NewTraceRecorder(period, snapshotThreshold time.Duration, bufferSizeBytes i
 	tr := &TraceRecorder{
 		FlightRecorder:    trace.NewFlightRecorder(),
 		snapshotThreshold: snapshotThreshold,
+		sink:              NewDirSink("."),
+		regionSnapshots:   &snapshotLimiter{cooldown: regionSnapshotCooldown},
 	}
 	tr.SetPeriod(period)
 	tr.SetSize(bufferSizeBytes)
@@ -29,25 +68,109 @@ func NewTraceRecorder(period, snapshotThreshold time.Duration, bufferSizeBytes i
 	return tr
 }
 
+// SetSink replaces the sink snapshots are written to. By default snapshots are stored in the working directory.
+func (tr *TraceRecorder) SetSink(sink SnapshotSink) {
+	tr.sink = sink
+}
+
+// SetRegionSnapshotCooldown sets the minimum time between two snapshots of slow regions, 1m by default.
+// Explicit snapshots, e.g. by Snapshot or WatchRuntime, aren't limited by it.
+func (tr *TraceRecorder) SetRegionSnapshotCooldown(cooldown time.Duration) {
+	tr.regionSnapshots.cooldown = cooldown
+}
+
+// SetAnalyze enables summarizing every snapshot with AnalyzeTrace. The summary is attached to the snapshot
+// metadata (e.g. the JSON sidecar of NewDirSink) and reported as trace.snapshot.* metrics.
+func (tr *TraceRecorder) SetAnalyze(enabled bool) {
+	tr.analyze = enabled
+}
+
+// SetTraceRecorder makes every function timed by ReportFuncTiming* helpers a region of tr.
+// Passing nil disables the integration.
+func SetTraceRecorder(tr *TraceRecorder) {
+	clientMux.Lock()
+	defer clientMux.Unlock()
+	traceRecorder = tr
+}
+
 // StartRegion starts measuring execution time of a region and if it passes the snapshotThreshold
-// then it flushes recorder trace buffer to file
+// then it flushes recorder trace buffer to the sink, at most once per cooldown, see SetRegionSnapshotCooldown
 func (tr TraceRecorder) StartRegion(tagName, tagValue string) (stopRegion func() error) {
+	_, stopRegion = tr.StartRegionCtx(context.Background(), tagName, tagValue)
+	return stopRegion
+}
+
+// StartRegionCtx is the same as StartRegion, but nests the region task under the task carried by ctx
+// and logs the OTel trace id of the span in ctx into the execution trace.
+func (tr TraceRecorder) StartRegionCtx(ctx context.Context, tagName, tagValue string) (context.Context, func() error) {
+	taskCtx, endRegion := tr.startRegion(ctx, tagName, tagValue)
+	return taskCtx, func() error {
+		write, err := endRegion()
+		if err != nil || write == nil {
+			return err
+		}
+		return write()
+	}
+}
+
+// startRegion is StartRegionCtx split in two: endRegion ends the task and, if the region passed the snapshotThreshold
+// and no other region snapshot is in flight or cooling down, captures the recorder buffer and returns the write
+// of the snapshot, so the caller can run the analysis and the sink I/O off its own path.
+func (tr TraceRecorder) startRegion(ctx context.Context, tagName, tagValue string) (context.Context, func() (func() error, error)) {
 	start := time.Now()
-	_, task := rtrace.NewTask(context.Background(), fmt.Sprintf("%s=%s", tagName, tagValue))
-	return func() error {
+	taskCtx, task := rtrace.NewTask(ctx, fmt.Sprintf("%s=%s", tagName, tagValue))
+	if sc := oteltrace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		rtrace.Log(taskCtx, "trace_id", sc.TraceID().String())
+		rtrace.Log(taskCtx, "span_id", sc.SpanID().String())
+	}
+	return taskCtx, func() (func() error, error) {
 		task.End()
-		if time.Since(start) > tr.snapshotThreshold { // snapshot trace
-			fileName := fmt.Sprintf("trace-%s-%s-%d.out", tagName, tagValue, start.Unix())
-			fmt.Printf("::: writing Trace Recorder snapshot to file %s :::\n", fileName)
-			f, err := os.Create(fileName)
-			if err != nil {
-				return err
-			}
-			_, err = tr.WriteTo(f)
+		d := time.Since(start)
+		if d <= tr.snapshotThreshold || !tr.regionSnapshots.acquire(time.Now()) {
+			return nil, nil
+		}
+		// snapshot trace
+		meta := newSnapshotMetadata(SnapshotKindTrace, fmt.Sprintf("%s-%s", tagName, tagValue), d, start)
+		write, err := tr.capture(taskCtx, meta)
+		if err != nil {
+			tr.regionSnapshots.release()
+			return nil, err
+		}
+		return func() error {
+			defer tr.regionSnapshots.release()
+			return write()
+		}, nil
+	}
+}
+
+func (tr TraceRecorder) snapshot(meta SnapshotMetadata) error {
+	write, err := tr.capture(context.Background(), meta)
+	if err != nil {
+		return err
+	}
+	return write()
+}
+
+// capture copies the recorder buffer and returns the func that analyzes and writes it to the sink,
+// ctx is the one of the region, if any.
+func (tr TraceRecorder) capture(ctx context.Context, meta SnapshotMetadata) (func() error, error) {
+	var buf bytes.Buffer
+	if _, err := tr.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		if tr.analyze {
+			summary, err := AnalyzeTrace(bytes.NewReader(buf.Bytes()))
 			if err != nil {
-				return err
+				contextLogger(ctx).WithError(err).Warningln("failed to analyze trace snapshot")
+			} else {
+				meta.Summary = summary
+				summary.Report(Tags{"region": meta.Region})
 			}
 		}
-		return nil
-	}
+
+		fmt.Printf("::: writing Trace Recorder snapshot %s :::\n", meta.BaseName())
+		return tr.sink.WriteSnapshot(meta, buf.Bytes())
+	}, nil
 }
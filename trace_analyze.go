@@ -0,0 +1,277 @@
+package metrics
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/trace"
+)
+
+// traceSummaryTopN limits the number of block reasons, goroutines, tasks and regions kept in a TraceSummary,
+// which also bounds the tag values reported by TraceSummary.Report.
+const traceSummaryTopN = 10
+
+// TraceSummary is a compact digest of an execution trace snapshot.
+type TraceSummary struct {
+	Duration          time.Duration        `json:"duration_ns"` // time between the first and the last event
+	GCTime            time.Duration        `json:"gc_ns"`       // time spent in the concurrent mark phase
+	STWTime           time.Duration        `json:"stw_ns"`      // time the world was stopped
+	BlockReasons      []TraceBlockStat     `json:"block_reasons"`
+	LongestGoroutines []TraceGoroutineStat `json:"longest_goroutines"`
+	Tasks             []TraceRegionStat    `json:"tasks"`
+	Regions           []TraceRegionStat    `json:"regions"`
+}
+
+// TraceBlockStat is the total time goroutines spent waiting for a single reason.
+type TraceBlockStat struct {
+	Reason   string        `json:"reason"`
+	Count    int           `json:"count"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// TraceGoroutineStat is the execution time of a single goroutine.
+type TraceGoroutineStat struct {
+	ID      int64         `json:"id"`
+	Func    string        `json:"func,omitempty"` // start function, if the goroutine was created within the snapshot
+	Running time.Duration `json:"running_ns"`
+	Blocked time.Duration `json:"blocked_ns"`
+}
+
+// TraceRegionStat is the total time spent in tasks or regions of the same type.
+type TraceRegionStat struct {
+	Name     string        `json:"name"`
+	Count    int           `json:"count"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+type goroutineState struct {
+	stat   TraceGoroutineStat
+	state  trace.GoState
+	since  trace.Time
+	reason string
+}
+
+type regionKey struct {
+	g    trace.GoID
+	name string
+}
+
+// AnalyzeTrace parses an execution trace (e.g. a TraceRecorder snapshot) and summarizes it.
+func AnalyzeTrace(r io.Reader) (*TraceSummary, error) {
+	reader, err := trace.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read trace")
+	}
+
+	var (
+		first, last trace.Time
+		summary     TraceSummary
+
+		goroutines = make(map[trace.GoID]*goroutineState)
+		blocked    = make(map[string]*TraceBlockStat)
+		ranges     = make(map[string]trace.Time)
+		tasks      = make(map[trace.TaskID]trace.Time)
+		taskStats  = make(map[string]*TraceRegionStat)
+		regions    = make(map[regionKey][]trace.Time)
+		regionStat = make(map[string]*TraceRegionStat)
+	)
+
+	for {
+		ev, err := reader.ReadEvent()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to read trace event")
+		}
+
+		ts := ev.Time()
+		if first == 0 {
+			first = ts
+		}
+		last = ts
+
+		switch ev.Kind() {
+		case trace.EventStateTransition:
+			st := ev.StateTransition()
+			if st.Resource.Kind != trace.ResourceGoroutine {
+				continue
+			}
+			id := st.Resource.Goroutine()
+			from, to := st.Goroutine()
+
+			g, ok := goroutines[id]
+			if !ok {
+				g = &goroutineState{stat: TraceGoroutineStat{ID: int64(id)}, since: first}
+				goroutines[id] = g
+			}
+			if from == trace.GoNotExist {
+				for frame := range st.Stack.Frames() {
+					g.stat.Func = frame.Func
+					break
+				}
+			}
+
+			elapsed := ts.Sub(g.since)
+			switch from {
+			case trace.GoRunning:
+				g.stat.Running += elapsed
+			case trace.GoWaiting:
+				g.stat.Blocked += elapsed
+				if g.reason != "" {
+					addBlockStat(blocked, g.reason, elapsed)
+				}
+			}
+			g.state, g.since, g.reason = to, ts, ""
+			if to == trace.GoWaiting {
+				g.reason = st.Reason
+			}
+
+		case trace.EventRangeBegin, trace.EventRangeActive:
+			rng := ev.Range()
+			start := ts
+			if ev.Kind() == trace.EventRangeActive {
+				start = first
+			}
+			ranges[rng.Name+"/"+rng.Scope.String()] = start
+
+		case trace.EventRangeEnd:
+			rng := ev.Range()
+			key := rng.Name + "/" + rng.Scope.String()
+			start, ok := ranges[key]
+			if !ok {
+				continue
+			}
+			delete(ranges, key)
+			switch {
+			case rng.Name == "GC concurrent mark phase":
+				summary.GCTime += ts.Sub(start)
+			case strings.HasPrefix(rng.Name, "stop-the-world"):
+				summary.STWTime += ts.Sub(start)
+			}
+
+		case trace.EventTaskBegin:
+			tasks[ev.Task().ID] = ts
+
+		case trace.EventTaskEnd:
+			task := ev.Task()
+			start, ok := tasks[task.ID]
+			if !ok {
+				continue
+			}
+			delete(tasks, task.ID)
+			addRegionStat(taskStats, task.Type, ts.Sub(start))
+
+		case trace.EventRegionBegin:
+			key := regionKey{g: ev.Goroutine(), name: ev.Region().Type}
+			regions[key] = append(regions[key], ts)
+
+		case trace.EventRegionEnd:
+			key := regionKey{g: ev.Goroutine(), name: ev.Region().Type}
+			starts := regions[key]
+			if len(starts) == 0 {
+				continue
+			}
+			start := starts[len(starts)-1]
+			regions[key] = starts[:len(starts)-1]
+			addRegionStat(regionStat, key.name, ts.Sub(start))
+		}
+	}
+
+	// close out goroutines that are still running or blocked when the snapshot ends
+	for _, g := range goroutines {
+		elapsed := last.Sub(g.since)
+		switch g.state {
+		case trace.GoRunning:
+			g.stat.Running += elapsed
+		case trace.GoWaiting:
+			g.stat.Blocked += elapsed
+			if g.reason != "" {
+				addBlockStat(blocked, g.reason, elapsed)
+			}
+		}
+	}
+
+	summary.Duration = last.Sub(first)
+
+	for _, b := range blocked {
+		summary.BlockReasons = append(summary.BlockReasons, *b)
+	}
+	sort.Slice(summary.BlockReasons, func(i, j int) bool {
+		return summary.BlockReasons[i].Duration > summary.BlockReasons[j].Duration
+	})
+	if len(summary.BlockReasons) > traceSummaryTopN {
+		summary.BlockReasons = summary.BlockReasons[:traceSummaryTopN]
+	}
+
+	for _, g := range goroutines {
+		if g.stat.Running > 0 {
+			summary.LongestGoroutines = append(summary.LongestGoroutines, g.stat)
+		}
+	}
+	sort.Slice(summary.LongestGoroutines, func(i, j int) bool {
+		return summary.LongestGoroutines[i].Running > summary.LongestGoroutines[j].Running
+	})
+	if len(summary.LongestGoroutines) > traceSummaryTopN {
+		summary.LongestGoroutines = summary.LongestGoroutines[:traceSummaryTopN]
+	}
+
+	summary.Tasks = sortedRegionStats(taskStats, traceSummaryTopN)
+	summary.Regions = sortedRegionStats(regionStat, traceSummaryTopN)
+
+	return &summary, nil
+}
+
+// Report emits the summary as metrics, so slow snapshots can be triaged from dashboards.
+func (s *TraceSummary) Report(tags ...Tags) {
+	base := MergeTags(nil, tags...)
+	Timer("trace.snapshot.duration", s.Duration, base)
+	Timer("trace.snapshot.gc", s.GCTime, base)
+	Timer("trace.snapshot.stw", s.STWTime, base)
+	for _, b := range s.BlockReasons {
+		Timer("trace.snapshot.blocked", b.Duration, MergeTags(base, Tags{"reason": b.Reason}))
+	}
+	for _, t := range s.Tasks {
+		Timer("trace.snapshot.task", t.Duration, MergeTags(base, Tags{"task": t.Name}))
+	}
+	for _, r := range s.Regions {
+		Timer("trace.snapshot.region", r.Duration, MergeTags(base, Tags{"trace_region": r.Name}))
+	}
+}
+
+func addBlockStat(stats map[string]*TraceBlockStat, reason string, d time.Duration) {
+	stat, ok := stats[reason]
+	if !ok {
+		stat = &TraceBlockStat{Reason: reason}
+		stats[reason] = stat
+	}
+	stat.Count++
+	stat.Duration += d
+}
+
+func addRegionStat(stats map[string]*TraceRegionStat, name string, d time.Duration) {
+	stat, ok := stats[name]
+	if !ok {
+		stat = &TraceRegionStat{Name: name}
+		stats[name] = stat
+	}
+	stat.Count++
+	stat.Duration += d
+}
+
+// sortedRegionStats returns the topN longest stats.
+func sortedRegionStats(stats map[string]*TraceRegionStat, topN int) []TraceRegionStat {
+	res := make([]TraceRegionStat, 0, len(stats))
+	for _, s := range stats {
+		res = append(res, *s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Duration > res[j].Duration
+	})
+	if len(res) > topN {
+		res = res[:topN]
+	}
+	return res
+}
@@ -0,0 +1,292 @@
+package metrics
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	SnapshotKindTrace = "trace" // execution trace taken by TraceRecorder
+	SnapshotKindCPU   = "cpu"   // pprof CPU profile
+	SnapshotKindHeap  = "heap"  // pprof heap profile
+)
+
+// SnapshotMetadata describes a single snapshot handed over to a SnapshotSink.
+type SnapshotMetadata struct {
+	Kind     string        `json:"kind"` // trace by default
+	Service  string        `json:"service"`
+	Version  string        `json:"version"`
+	Host     string        `json:"host"`
+	Region   string        `json:"region"`
+	Duration time.Duration `json:"duration_ns"`
+	Time     time.Time     `json:"time"`
+
+	Summary *TraceSummary `json:"summary,omitempty"` // set when the recorder analyzes snapshots
+}
+
+// BaseName returns the file name (without extension) used by sinks to store the snapshot.
+func (m SnapshotMetadata) BaseName() string {
+	region := strings.NewReplacer("/", "_", string(os.PathSeparator), "_", " ", "_").Replace(m.Region)
+	return fmt.Sprintf("%s-%s-%d", m.kind(), region, m.Time.UnixNano())
+}
+
+// Ext returns the file extension matching the snapshot kind.
+func (m SnapshotMetadata) Ext() string {
+	if m.kind() == SnapshotKindTrace {
+		return ".out"
+	}
+	return ".pb.gz"
+}
+
+func (m SnapshotMetadata) kind() string {
+	if m.Kind == "" {
+		return SnapshotKindTrace
+	}
+	return m.Kind
+}
+
+// SnapshotSink receives execution trace snapshots taken by TraceRecorder and profiles captured on slow functions.
+type SnapshotSink interface {
+	WriteSnapshot(meta SnapshotMetadata, data []byte) error
+}
+
+func newSnapshotMetadata(kind, region string, d time.Duration, start time.Time) SnapshotMetadata {
+	meta := SnapshotMetadata{
+		Kind:     kind,
+		Region:   region,
+		Duration: d,
+		Time:     start,
+	}
+	if config != nil {
+		meta.Service = config.Prefix
+		meta.Version = config.Version
+		meta.Host = config.HostName
+	}
+	return meta
+}
+
+type dirSink struct {
+	dir      string
+	maxFiles int
+	maxAge   time.Duration
+}
+
+// NewDirSink creates a sink that stores every snapshot as <name>.out (or <name>.pb.gz for profiles) in dir,
+// with the metadata written next to it as <name>.json.
+func NewDirSink(dir string) SnapshotSink {
+	return &dirSink{dir: dir}
+}
+
+// NewDirSinkWithRetention is the same as NewDirSink, but keeps at most maxSnapshots snapshots no older than maxAge,
+// removing the oldest ones after every write. Zero values disable the corresponding limit.
+func NewDirSinkWithRetention(dir string, maxSnapshots int, maxAge time.Duration) SnapshotSink {
+	return &dirSink{
+		dir:      dir,
+		maxFiles: maxSnapshots,
+		maxAge:   maxAge,
+	}
+}
+
+func (s *dirSink) WriteSnapshot(meta SnapshotMetadata, data []byte) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return errors.Wrap(err, "failed to create snapshot dir")
+	}
+
+	metaJSON, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	base := filepath.Join(s.dir, meta.BaseName())
+	if err := os.WriteFile(base+meta.Ext(), data, 0o644); err != nil {
+		return errors.Wrap(err, "failed to write snapshot")
+	}
+	if err := os.WriteFile(base+".json", metaJSON, 0o644); err != nil {
+		return errors.Wrap(err, "failed to write snapshot metadata")
+	}
+	return s.prune()
+}
+
+// prune removes the oldest snapshots exceeding the retention limits. Every snapshot is identified
+// by its metadata sidecar, the snapshot files with the same base name are removed together, sidecar last.
+// Names are matched exactly rather than globbed, since regions may contain glob metacharacters.
+func (s *dirSink) prune() error {
+	if s.maxFiles <= 0 && s.maxAge <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var sidecars []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			sidecars = append(sidecars, filepath.Join(s.dir, entry.Name()))
+		}
+	}
+
+	type snapshotFile struct {
+		base    string
+		modTime time.Time
+	}
+	snapshots := make([]snapshotFile, 0, len(sidecars))
+	for _, sidecar := range sidecars {
+		info, err := os.Stat(sidecar)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshotFile{
+			base:    strings.TrimSuffix(sidecar, ".json"),
+			modTime: info.ModTime(),
+		})
+	}
+	// newest first
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].modTime.After(snapshots[j].modTime)
+	})
+
+	for i, snapshot := range snapshots {
+		expired := s.maxAge > 0 && time.Since(snapshot.modTime) > s.maxAge
+		if !expired && (s.maxFiles <= 0 || i < s.maxFiles) {
+			continue
+		}
+		for _, ext := range []string{".out", ".pb.gz", ".json"} {
+			if err := os.Remove(snapshot.base + ext); err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "failed to remove old snapshot")
+			}
+		}
+	}
+	return nil
+}
+
+type tarballSink struct {
+	dir string
+}
+
+// NewTarballSink creates a sink that stores every snapshot as a single <name>.tar.gz in dir,
+// containing the snapshot (e.g. trace.out) and metadata.json.
+func NewTarballSink(dir string) SnapshotSink {
+	return &tarballSink{dir: dir}
+}
+
+func (s *tarballSink) WriteSnapshot(meta SnapshotMetadata, data []byte) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return errors.Wrap(err, "failed to create snapshot dir")
+	}
+
+	var buf bytes.Buffer
+	if err := writeSnapshotTarball(&buf, meta, data); err != nil {
+		return err
+	}
+
+	fileName := filepath.Join(s.dir, meta.BaseName()+".tar.gz")
+	if err := os.WriteFile(fileName, buf.Bytes(), 0o644); err != nil {
+		return errors.Wrap(err, "failed to write snapshot tarball")
+	}
+	return nil
+}
+
+func writeSnapshotTarball(w io.Writer, meta SnapshotMetadata, data []byte) error {
+	metaJSON, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	files := []struct {
+		name string
+		body []byte
+	}{
+		{meta.kind() + meta.Ext(), data},
+		{"metadata.json", metaJSON},
+	}
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    f.name,
+			Mode:    0o644,
+			Size:    int64(len(f.body)),
+			ModTime: meta.Time,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.body); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// HTTPSink uploads every snapshot to URL, passing the metadata as X-Snapshot-* headers.
+type HTTPSink struct {
+	URL    string
+	Method string      // PUT by default
+	Header http.Header // extra headers, e.g. authorization
+	Client *http.Client
+}
+
+// NewHTTPSink creates a sink that uploads snapshots to url with a PUT request.
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{
+		URL:    url,
+		Method: http.MethodPut,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *HTTPSink) WriteSnapshot(meta SnapshotMetadata, data []byte) error {
+	method := s.Method
+	if method == "" {
+		method = http.MethodPut
+	}
+	req, err := http.NewRequest(method, s.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Snapshot-Name", meta.BaseName()+meta.Ext())
+	req.Header.Set("X-Snapshot-Kind", meta.kind())
+	req.Header.Set("X-Snapshot-Service", meta.Service)
+	req.Header.Set("X-Snapshot-Version", meta.Version)
+	req.Header.Set("X-Snapshot-Host", meta.Host)
+	req.Header.Set("X-Snapshot-Region", meta.Region)
+	req.Header.Set("X-Snapshot-Duration", meta.Duration.String())
+	req.Header.Set("X-Snapshot-Time", strconv.FormatInt(meta.Time.Unix(), 10))
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "snapshot upload failed")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("snapshot upload failed: %s", resp.Status)
+	}
+	return nil
+}
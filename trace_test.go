@@ -0,0 +1,517 @@
+package metrics
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	rmetrics "runtime/metrics"
+	rtrace "runtime/trace"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/trace"
+)
+
+type uploadedSnapshot struct {
+	method string
+	header http.Header
+	body   []byte
+}
+
+func newSnapshotServer(t *testing.T) (*httptest.Server, func() []uploadedSnapshot) {
+	t.Helper()
+	var (
+		mux     sync.Mutex
+		uploads []uploadedSnapshot
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mux.Lock()
+		uploads = append(uploads, uploadedSnapshot{method: r.Method, header: r.Header.Clone(), body: body})
+		mux.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []uploadedSnapshot {
+		mux.Lock()
+		defer mux.Unlock()
+		return append([]uploadedSnapshot(nil), uploads...)
+	}
+}
+
+func testSnapshotMetadata() SnapshotMetadata {
+	return SnapshotMetadata{
+		Service:  "svc",
+		Version:  "v1.2.3",
+		Host:     "host1",
+		Region:   "func_name-Foo",
+		Duration: 1500 * time.Millisecond,
+		Time:     time.Unix(1700000000, 0),
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	srv, uploads := newSnapshotServer(t)
+
+	sink := NewHTTPSink(srv.URL)
+	sink.Header = http.Header{"Authorization": []string{"Bearer token"}}
+	require.NoError(t, sink.WriteSnapshot(testSnapshotMetadata(), []byte("trace-data")))
+
+	got := uploads()
+	require.Len(t, got, 1)
+	assert.Equal(t, http.MethodPut, got[0].method)
+	assert.Equal(t, "trace-data", string(got[0].body))
+	assert.Equal(t, "Bearer token", got[0].header.Get("Authorization"))
+	assert.Equal(t, "svc", got[0].header.Get("X-Snapshot-Service"))
+	assert.Equal(t, "v1.2.3", got[0].header.Get("X-Snapshot-Version"))
+	assert.Equal(t, "host1", got[0].header.Get("X-Snapshot-Host"))
+	assert.Equal(t, "func_name-Foo", got[0].header.Get("X-Snapshot-Region"))
+	assert.Equal(t, "1.5s", got[0].header.Get("X-Snapshot-Duration"))
+	assert.Equal(t, "trace-func_name-Foo-1700000000000000000.out", got[0].header.Get("X-Snapshot-Name"))
+}
+
+func TestHTTPSinkError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	sink := NewHTTPSink(srv.URL)
+	sink.Method = http.MethodPost
+	err := sink.WriteSnapshot(testSnapshotMetadata(), []byte("trace-data"))
+	assert.ErrorContains(t, err, "403")
+}
+
+func TestDirSink(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested")
+	require.NoError(t, NewDirSink(dir).WriteSnapshot(testSnapshotMetadata(), []byte("trace-data")))
+
+	data, err := os.ReadFile(filepath.Join(dir, "trace-func_name-Foo-1700000000000000000.out"))
+	require.NoError(t, err)
+	assert.Equal(t, "trace-data", string(data))
+
+	metaJSON, err := os.ReadFile(filepath.Join(dir, "trace-func_name-Foo-1700000000000000000.json"))
+	require.NoError(t, err)
+	var meta SnapshotMetadata
+	require.NoError(t, json.Unmarshal(metaJSON, &meta))
+	assert.Equal(t, "svc", meta.Service)
+	assert.Equal(t, 1500*time.Millisecond, meta.Duration)
+}
+
+func TestTarballSink(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, NewTarballSink(dir).WriteSnapshot(testSnapshotMetadata(), []byte("trace-data")))
+
+	f, err := os.Open(filepath.Join(dir, "trace-func_name-Foo-1700000000000000000.tar.gz"))
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = string(body)
+	}
+	assert.Equal(t, "trace-data", files["trace.out"])
+	assert.Contains(t, files["metadata.json"], `"region": "func_name-Foo"`)
+}
+
+func TestTraceRecorderUploadsSnapshot(t *testing.T) {
+	srv, uploads := newSnapshotServer(t)
+
+	prevConfig := config
+	config = &StatterConfig{Prefix: "svc", Version: "v1", HostName: "host1"}
+	t.Cleanup(func() { config = prevConfig })
+
+	tr := NewTraceRecorder(time.Second, time.Millisecond, 1<<20)
+	tr.SetSink(NewHTTPSink(srv.URL))
+	require.NoError(t, tr.Start())
+	defer tr.Stop()
+
+	stop := tr.StartRegion("func_name", "slow")
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, stop())
+
+	tr.snapshotThreshold = time.Hour
+	stop = tr.StartRegion("func_name", "fast")
+	require.NoError(t, stop())
+
+	got := uploads()
+	require.Len(t, got, 1)
+	assert.NotEmpty(t, got[0].body)
+	assert.Equal(t, "svc", got[0].header.Get("X-Snapshot-Service"))
+	assert.Equal(t, "v1", got[0].header.Get("X-Snapshot-Version"))
+	assert.Equal(t, "host1", got[0].header.Get("X-Snapshot-Host"))
+	assert.Equal(t, "func_name-slow", got[0].header.Get("X-Snapshot-Region"))
+}
+
+type captureSink struct {
+	mux       sync.Mutex
+	snapshots []SnapshotMetadata
+	data      [][]byte
+}
+
+func (s *captureSink) WriteSnapshot(meta SnapshotMetadata, data []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.snapshots = append(s.snapshots, meta)
+	s.data = append(s.data, data)
+	return nil
+}
+
+// last waits for a snapshot, since timed functions write them in the background.
+func (s *captureSink) last(t *testing.T) (SnapshotMetadata, []byte) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		return len(s.snapshots) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.snapshots[len(s.snapshots)-1], s.data[len(s.data)-1]
+}
+
+func TestReportFuncTimingCtxTraceRegion(t *testing.T) {
+	record(t)
+
+	prevTracer := tracer
+	tracer = sdktrace.NewTracerProvider().Tracer("test")
+	t.Cleanup(func() { tracer = prevTracer })
+
+	sink := &captureSink{}
+	tr := NewTraceRecorder(time.Second, 0, 1<<20)
+	tr.SetSink(sink)
+	require.NoError(t, tr.Start())
+	defer tr.Stop()
+	SetTraceRecorder(tr)
+	t.Cleanup(func() { SetTraceRecorder(nil) })
+
+	parentCtx, parentTask := rtrace.NewTask(context.Background(), "parent")
+	ctx, stop := ReportFuncTimingCtx(parentCtx)
+	traceID := oteltrace.SpanContextFromContext(ctx).TraceID().String()
+	stop()
+	parentTask.End()
+
+	meta, data := sink.last(t)
+	assert.Equal(t, "func_name-TestReportFuncTimingCtxTraceRegion", meta.Region)
+
+	r, err := trace.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	tasks := make(map[trace.TaskID]trace.Task)
+	logs := make(map[trace.TaskID]map[string]string)
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		switch ev.Kind() {
+		case trace.EventTaskBegin:
+			tasks[ev.Task().ID] = ev.Task()
+		case trace.EventLog:
+			l := ev.Log()
+			if logs[l.Task] == nil {
+				logs[l.Task] = make(map[string]string)
+			}
+			logs[l.Task][l.Category] = l.Message
+		}
+	}
+
+	var parent, region trace.Task
+	for _, task := range tasks {
+		switch task.Type {
+		case "parent":
+			parent = task
+		case "func_name=TestReportFuncTimingCtxTraceRegion":
+			region = task
+		}
+	}
+	require.NotZero(t, parent.ID)
+	require.NotZero(t, region.ID)
+
+	// the OTel SDK opens its own task for the span, so the region may be nested one level deeper
+	ancestors := make(map[trace.TaskID]bool)
+	for id := region.Parent; id != trace.NoTask && !ancestors[id]; id = tasks[id].Parent {
+		ancestors[id] = true
+	}
+	assert.True(t, ancestors[parent.ID], "region task should be nested under the parent task")
+	assert.Equal(t, traceID, logs[region.ID]["trace_id"])
+}
+
+func TestTraceRecorderHTTPHandler(t *testing.T) {
+	sink := &captureSink{}
+	tr := NewTraceRecorder(time.Second, time.Hour, 1<<20)
+	tr.SetSink(sink)
+
+	srv := httptest.NewServer(tr.HTTPHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?reason=incident")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, http.MethodPost, resp.Header.Get("Allow"))
+
+	resp, err = http.Post(srv.URL+"?reason="+strings.Repeat("x", maxSnapshotReasonLen+1), "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// recorder is not started yet, so there is nothing to snapshot
+	resp, err = http.Post(srv.URL+"?reason=incident", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	require.NoError(t, tr.Start())
+	defer tr.Stop()
+
+	resp, err = http.Post(srv.URL+"?reason=incident", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	meta, data := sink.last(t)
+	assert.Equal(t, "incident", meta.Region)
+	assert.NotEmpty(t, data)
+}
+
+func TestTraceRecorderWatchRuntime(t *testing.T) {
+	sink := &captureSink{}
+	tr := NewTraceRecorder(time.Second, time.Hour, 1<<20)
+	tr.SetSink(sink)
+	require.NoError(t, tr.Start())
+	defer tr.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr.WatchRuntime(ctx, RuntimeThresholds{
+		Goroutines:    1,
+		CheckInterval: 5 * time.Millisecond,
+		Cooldown:      time.Hour,
+	})
+
+	require.Eventually(t, func() bool {
+		sink.mux.Lock()
+		defer sink.mux.Unlock()
+		return len(sink.snapshots) > 0
+	}, time.Second, 5*time.Millisecond)
+
+	// cooldown prevents snapshot storms while the threshold stays exceeded
+	time.Sleep(50 * time.Millisecond)
+	sink.mux.Lock()
+	defer sink.mux.Unlock()
+	require.Len(t, sink.snapshots, 1)
+	assert.Equal(t, "goroutines", sink.snapshots[0].Region)
+}
+
+func TestRuntimeWatcherGCPause(t *testing.T) {
+	w := newRuntimeWatcher(RuntimeThresholds{GCPause: 10 * time.Millisecond})
+	h := &rmetrics.Float64Histogram{
+		Counts:  []uint64{5, 1, 0},
+		Buckets: []float64{math.Inf(-1), 0.001, 0.05, math.Inf(1)},
+	}
+	// first observation only records the baseline
+	assert.Zero(t, w.longestNewPause(h))
+
+	h.Counts = []uint64{6, 1, 0}
+	assert.Zero(t, w.longestNewPause(h))
+
+	h.Counts = []uint64{6, 2, 0}
+	assert.Equal(t, time.Millisecond, w.longestNewPause(h))
+
+	h.Counts = []uint64{7, 2, 1}
+	assert.Equal(t, 50*time.Millisecond, w.longestNewPause(h))
+}
+
+func TestAnalyzeTrace(t *testing.T) {
+	rec := record(t)
+
+	dir := t.TempDir()
+	tr := NewTraceRecorder(time.Second, 0, 1<<20)
+	tr.SetSink(NewDirSink(dir))
+	tr.SetAnalyze(true)
+	require.NoError(t, tr.Start())
+	defer tr.Stop()
+
+	stop := tr.StartRegion("func_name", "analyzed")
+	rtrace.WithRegion(context.Background(), "waiting", func() {
+		c := make(chan struct{})
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			close(c)
+		}()
+		<-c
+	})
+	require.NoError(t, stop())
+
+	matches, err := filepath.Glob(filepath.Join(dir, "trace-func_name-analyzed-*.json"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	metaJSON, err := os.ReadFile(matches[0])
+	require.NoError(t, err)
+
+	var meta SnapshotMetadata
+	require.NoError(t, json.Unmarshal(metaJSON, &meta))
+	require.NotNil(t, meta.Summary)
+	summary := meta.Summary
+
+	assert.Positive(t, summary.Duration)
+	assert.NotEmpty(t, summary.LongestGoroutines)
+	require.NotEmpty(t, summary.BlockReasons)
+
+	var chanBlocked time.Duration
+	for _, b := range summary.BlockReasons {
+		if strings.Contains(b.Reason, "chan") {
+			chanBlocked += b.Duration
+		}
+	}
+	assert.GreaterOrEqual(t, chanBlocked, 10*time.Millisecond)
+
+	require.NotEmpty(t, summary.Regions)
+	assert.Equal(t, "waiting", summary.Regions[0].Name)
+	assert.GreaterOrEqual(t, summary.Regions[0].Duration, 10*time.Millisecond)
+
+	var reported bool
+	for _, call := range rec.calls {
+		if call[0] == "Timing" && call[1] == "trace.snapshot.region" {
+			assert.Contains(t, call[3], "trace_region=waiting")
+			assert.Contains(t, call[3], "region=func_name-analyzed")
+			reported = true
+		}
+	}
+	assert.True(t, reported)
+}
+
+func TestDirSinkRetention(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewDirSinkWithRetention(dir, 2, 0)
+
+	for i := 0; i < 4; i++ {
+		meta := testSnapshotMetadata()
+		meta.Time = meta.Time.Add(time.Duration(i) * time.Second)
+		if i%2 == 1 {
+			meta.Kind = SnapshotKindCPU
+		}
+		require.NoError(t, sink.WriteSnapshot(meta, []byte("data")))
+		// make sure modification times differ
+		past := time.Now().Add(time.Duration(i-10) * time.Second)
+		base := filepath.Join(dir, meta.BaseName())
+		require.NoError(t, os.Chtimes(base+".json", past, past))
+	}
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	assert.ElementsMatch(t, []string{
+		"trace-func_name-Foo-1700000002000000000.out",
+		"trace-func_name-Foo-1700000002000000000.json",
+		"cpu-func_name-Foo-1700000003000000000.pb.gz",
+		"cpu-func_name-Foo-1700000003000000000.json",
+	}, names)
+}
+
+func TestDirSinkRetentionGlobRegion(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewDirSinkWithRetention(dir, 1, 0)
+
+	old := testSnapshotMetadata()
+	old.Region = "func_name-Foo[*]?"
+	require.NoError(t, sink.WriteSnapshot(old, []byte("data")))
+	past := time.Now().Add(-time.Minute)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, old.BaseName()+".json"), past, past))
+	// would be matched by a "func_name-Foo[*]?-<time>.*" glob
+	unrelated := filepath.Join(dir, "trace-func_name-Foo*x-1700000000000000000.out")
+	require.NoError(t, os.WriteFile(unrelated, []byte("data"), 0o644))
+
+	meta := testSnapshotMetadata()
+	meta.Time = meta.Time.Add(time.Second)
+	require.NoError(t, sink.WriteSnapshot(meta, []byte("data")))
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	assert.ElementsMatch(t, []string{
+		"trace-func_name-Foo*x-1700000000000000000.out",
+		"trace-func_name-Foo-1700000001000000000.out",
+		"trace-func_name-Foo-1700000001000000000.json",
+	}, names)
+}
+
+func TestRegionSnapshotsLimited(t *testing.T) {
+	record(t)
+
+	sink := &captureSink{}
+	tr := NewTraceRecorder(time.Second, 0, 1<<20)
+	tr.SetSink(sink)
+	require.NoError(t, tr.Start())
+	defer tr.Stop()
+	SetTraceRecorder(tr)
+	t.Cleanup(func() { SetTraceRecorder(nil) })
+
+	for i := 0; i < 20; i++ {
+		_, stop := ReportFuncTimingCtx(context.Background())
+		stop()
+	}
+
+	sink.last(t)
+	// give skipped regions a chance to show up, they must not
+	time.Sleep(100 * time.Millisecond)
+	sink.mux.Lock()
+	defer sink.mux.Unlock()
+	assert.Len(t, sink.snapshots, 1)
+}
+
+func TestSnapshotLimiter(t *testing.T) {
+	l := &snapshotLimiter{}
+	now := time.Now()
+	assert.True(t, l.acquire(now))
+	assert.False(t, l.acquire(now), "one snapshot in flight at most")
+	l.release()
+	assert.True(t, l.acquire(now))
+	l.release()
+
+	l = &snapshotLimiter{cooldown: time.Minute}
+	assert.True(t, l.acquire(now))
+	l.release()
+	assert.False(t, l.acquire(now.Add(time.Second)))
+	assert.True(t, l.acquire(now.Add(time.Minute)))
+}
+
+func TestSnapshotBaseNameUnique(t *testing.T) {
+	first := testSnapshotMetadata()
+	second := testSnapshotMetadata()
+	second.Time = second.Time.Add(time.Millisecond)
+	assert.NotEqual(t, first.BaseName(), second.BaseName(), "snapshots of a region in the same second must not overwrite each other")
+}
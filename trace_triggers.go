@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	rmetrics "runtime/metrics"
+	"time"
+)
+
+// maxSnapshotReasonLen limits the reason of HTTPHandler requests, since it ends up in file names and tags.
+const maxSnapshotReasonLen = 64
+
+const (
+	goroutinesMetric  = "/sched/goroutines:goroutines"
+	heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+	gcPausesMetric    = "/sched/pauses/total/gc:seconds"
+)
+
+// Snapshot flushes the recorder buffer to the sink right away, reason is used as the region name.
+func (tr TraceRecorder) Snapshot(reason string) error {
+	Incr("trace_recorder.snapshot", "reason", reason)
+	return tr.snapshot(newSnapshotMetadata(SnapshotKindTrace, reason, 0, time.Now()))
+}
+
+// HTTPHandler returns a handler that snapshots the recorder buffer on every POST request.
+// Optional "reason" query parameter is used as the region name ("http" by default), up to 64 bytes long.
+func (tr *TraceRecorder) HTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		reason := r.URL.Query().Get("reason")
+		if reason == "" {
+			reason = "http"
+		}
+		if len(reason) > maxSnapshotReasonLen {
+			http.Error(w, fmt.Sprintf("reason longer than %d bytes", maxSnapshotReasonLen), http.StatusBadRequest)
+			return
+		}
+		if err := tr.Snapshot(reason); err != nil {
+			http.Error(w, "snapshot failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "snapshot %s written\n", reason)
+	})
+}
+
+// SnapshotOnSignal snapshots the recorder buffer every time one of sigs (e.g. syscall.SIGUSR2) is received,
+// until ctx is done.
+func (tr *TraceRecorder) SnapshotOnSignal(ctx context.Context, sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		return
+	}
+
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, sigs...)
+	go func() {
+		defer signal.Stop(sigC)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-sigC:
+				if err := tr.Snapshot("signal-" + sig.String()); err != nil {
+					contextLogger(ctx).WithError(err).Warningln("failed to snapshot trace on signal")
+				}
+			}
+		}
+	}()
+}
+
+// RuntimeThresholds configures WatchRuntime. Zero thresholds are not checked.
+type RuntimeThresholds struct {
+	Goroutines    uint64        // snapshot when number of live goroutines exceeds this value
+	HeapBytes     uint64        // snapshot when size of live heap objects exceeds this value
+	GCPause       time.Duration // snapshot when a GC pause longer than this value is observed
+	CheckInterval time.Duration // how often runtime metrics are sampled, 1s by default
+	Cooldown      time.Duration // minimum time between two triggered snapshots, 1m by default
+}
+
+// WatchRuntime samples runtime/metrics every CheckInterval and snapshots the recorder buffer
+// when goroutine count, heap size or GC pause passes the configured threshold, until ctx is done.
+func (tr *TraceRecorder) WatchRuntime(ctx context.Context, th RuntimeThresholds) {
+	if th.CheckInterval <= 0 {
+		th.CheckInterval = time.Second
+	}
+	if th.Cooldown <= 0 {
+		th.Cooldown = time.Minute
+	}
+
+	w := newRuntimeWatcher(th)
+	go func() {
+		ticker := time.NewTicker(th.CheckInterval)
+		defer ticker.Stop()
+
+		var lastSnapshot time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reason := w.check()
+				if reason == "" || time.Since(lastSnapshot) < th.Cooldown {
+					continue
+				}
+				lastSnapshot = time.Now()
+				if err := tr.Snapshot(reason); err != nil {
+					contextLogger(ctx).WithError(err).Warningln("failed to snapshot trace on runtime threshold")
+				}
+			}
+		}
+	}()
+}
+
+type runtimeWatcher struct {
+	th        RuntimeThresholds
+	samples   []rmetrics.Sample
+	gcPauses  []uint64
+	gcStarted bool
+}
+
+func newRuntimeWatcher(th RuntimeThresholds) *runtimeWatcher {
+	return &runtimeWatcher{
+		th: th,
+		samples: []rmetrics.Sample{
+			{Name: goroutinesMetric},
+			{Name: heapObjectsMetric},
+			{Name: gcPausesMetric},
+		},
+	}
+}
+
+// check reads runtime metrics and returns the name of the first exceeded threshold, if any.
+func (w *runtimeWatcher) check() string {
+	rmetrics.Read(w.samples)
+
+	var reason string
+	for _, s := range w.samples {
+		switch s.Name {
+		case goroutinesMetric:
+			if w.th.Goroutines > 0 && s.Value.Kind() == rmetrics.KindUint64 && s.Value.Uint64() > w.th.Goroutines && reason == "" {
+				reason = "goroutines"
+			}
+		case heapObjectsMetric:
+			if w.th.HeapBytes > 0 && s.Value.Kind() == rmetrics.KindUint64 && s.Value.Uint64() > w.th.HeapBytes && reason == "" {
+				reason = "heap"
+			}
+		case gcPausesMetric:
+			if s.Value.Kind() != rmetrics.KindFloat64Histogram {
+				continue
+			}
+			// the histogram is cumulative, so the pause buckets need to be tracked between checks
+			longest := w.longestNewPause(s.Value.Float64Histogram())
+			if w.th.GCPause > 0 && longest > w.th.GCPause && reason == "" {
+				reason = "gc_pause"
+			}
+		}
+	}
+	return reason
+}
+
+// longestNewPause returns the lower bound of the longest GC pause bucket that got new observations since the last call.
+func (w *runtimeWatcher) longestNewPause(h *rmetrics.Float64Histogram) time.Duration {
+	prev := w.gcPauses
+	w.gcPauses = append(w.gcPauses[:0:0], h.Counts...)
+	if !w.gcStarted {
+		w.gcStarted = true
+		return 0
+	}
+
+	for i := len(h.Counts) - 1; i >= 0; i-- {
+		if i < len(prev) && h.Counts[i] <= prev[i] {
+			continue
+		}
+		lower := h.Buckets[i]
+		if math.IsInf(lower, -1) {
+			return 0
+		}
+		return time.Duration(lower * float64(time.Second))
+	}
+	return 0
+}